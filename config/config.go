@@ -1,32 +1,210 @@
 package config
 
 import (
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"unsafe"
 
 	"github.com/spf13/viper"
 )
 
 // New returns a configured viper instance
+//
+// args are a k, v scheme. Special keys are:
+// * env-prefix - sets the prefix to strip from environment variables when resolving keys.
+// * env-replace - takes a comma separated list of old,new. Default: .,_,-,_
+// * config-file - a comma separated list of files merged in order. Default: $DDL_CONFIG_FILE
+// * config-path - a comma separated list of directories searched for config-name. Default: $DDL_CONFIG_PATH
+// * config-name - the file name, without extension, searched for in config-path. Default: $DDL_CONFIG_NAME or "config"
+// * config-env - the environment overlay merged over each file, e.g. config.production.yaml. Default: $DDL_CONFIG_ENV
+// * config-watch - "true" to Watch the instance for changes. Default: $DDL_CONFIG_WATCH
+// * config-flags - "true" to read the flags bound with BindFlags or BindKingpin. Default: $DDL_CONFIG_FLAGS
+//
+// The remaining k,v's are treated as viper BindEnv args where k is the viper key and v is the env variable.
+//
+// Values are layered file, then environment overlay file, then environment variables, then flags given
+// to NewWithFlags or bound with config-flags, with the latter taking precedence.  Files are keyed the same way as the environment, so DDL_RPC_PORT is read from:
+//
+//	ddl:
+//	  rpc:
+//	    port: 8080
+//
+// Values that are references, such as file:///run/secrets/tls.key, are replaced with what they point
+// to.  See Resolve.
 func New(prefix string, args ...string) (*viper.Viper, error) {
-	v := viper.New()
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
-	v.SetEnvPrefix(prefix)
-	v.AutomaticEnv()
+	return NewWithFlags(prefix, nil, args...)
+}
+
+// NewWithFlags is New, with flags taking precedence over every other source.  Flags are keyed the same
+// way as files, so --ddl.rpc.port sets the port of DDL_RPC:
+//
+//	v, err := config.NewWithFlags("DDL_RPC", config.PFlags(pflag.CommandLine))
+func NewWithFlags(prefix string, flags Flags, args ...string) (*viper.Viper, error) {
+	s := parse(prefix, args...)
+	if flags != nil {
+		s.flagSets = append(s.flagSets, flags)
+	}
+
+	v, err := s.build()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.watch {
+		if _, err := Watch(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// source records how a viper instance was assembled.
+type source struct {
+	prefix   string
+	replacer *strings.Replacer
+	bindings map[string]string
+	files    files
+	watch    bool
+	resolved map[string]string
+	settings map[string]interface{}
+	consumed []field
+	flags    map[string]viper.FlagValue
+	// flagSets and, with boundFlags, the flags of BindFlags are bound to the instance.
+	flagSets   []Flags
+	boundFlags bool
+}
+
+// sources maps each viper instance built by New to the source it was built from.  Instances are
+// keyed by address so that the map doesn't keep them alive; a finalizer removes the entry once an
+// instance is collected.
+var sources = struct {
+	sync.RWMutex
+	m map[uintptr]*source
+}{
+	m: make(map[uintptr]*source),
+}
+
+// sourceOf returns the source v was built from.  Instances that did not come from New are
+// treated as if they were built without a prefix.
+func sourceOf(v *viper.Viper) *source {
+	sources.RLock()
+	s, ok := sources.m[addr(v)]
+	sources.RUnlock()
+	if !ok {
+		return parse("")
+	}
+	return s
+}
+
+func parse(prefix string, args ...string) *source {
+	s := source{
+		prefix:   prefix,
+		replacer: strings.NewReplacer(".", "_", "-", "_"),
+		bindings: make(map[string]string),
+		resolved: make(map[string]string),
+		flags:    make(map[string]viper.FlagValue),
+		files: files{
+			names: os.Getenv("DDL_CONFIG_FILE"),
+			paths: os.Getenv("DDL_CONFIG_PATH"),
+			name:  os.Getenv("DDL_CONFIG_NAME"),
+			env:   os.Getenv("DDL_CONFIG_ENV"),
+		},
+	}
+
+	s.watch, _ = strconv.ParseBool(os.Getenv("DDL_CONFIG_WATCH"))
+	s.boundFlags, _ = strconv.ParseBool(os.Getenv("DDL_CONFIG_FLAGS"))
 
 	for i, j := 0, 1; j < len(args); i, j = i+2, j+2 {
 		key, val := args[i], args[j]
 		switch key {
 		case "env-prefix":
-			v.SetEnvPrefix(val)
+			s.prefix = val
 		case "env-replace":
 			x := strings.Split(val, ",")
-			v.SetEnvKeyReplacer(strings.NewReplacer(x...))
+			s.replacer = strings.NewReplacer(x...)
+		case "config-file":
+			s.files.names = val
+		case "config-path":
+			s.files.paths = val
+		case "config-name":
+			s.files.name = val
+		case "config-env":
+			s.files.env = val
+		case "config-watch":
+			s.watch, _ = strconv.ParseBool(val)
+		case "config-flags":
+			s.boundFlags, _ = strconv.ParseBool(val)
 		default:
-			if err := v.BindEnv(key, val); err != nil {
-				return nil, err
-			}
+			s.bindings[strings.ToLower(key)] = val
+		}
+	}
+
+	return &s
+}
+
+func (s *source) build() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetEnvKeyReplacer(s.replacer)
+	v.SetEnvPrefix(s.prefix)
+	v.AutomaticEnv()
+
+	for key, env := range s.bindings {
+		if err := v.BindEnv(key, env); err != nil {
+			return nil, err
 		}
 	}
 
+	if err := s.bindFlags(v); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.files.load(s.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+	s.settings = settings
+
+	if err := s.resolveAll(v); err != nil {
+		return nil, err
+	}
+
+	sources.Lock()
+	sources.m[addr(v)] = s
+	sources.Unlock()
+	runtime.SetFinalizer(v, forget)
+
 	return v, nil
 }
+
+func addr(v *viper.Viper) uintptr {
+	return uintptr(unsafe.Pointer(v))
+}
+
+// forget drops the source of a collected instance.
+func forget(v *viper.Viper) {
+	sources.Lock()
+	delete(sources.m, addr(v))
+	sources.Unlock()
+}
+
+// envName returns the environment variable viper consults for key.
+func (s *source) envName(key string) string {
+	if env, ok := s.bindings[strings.ToLower(key)]; ok {
+		return env
+	}
+
+	name := strings.ToUpper(key)
+	if s.prefix != "" {
+		name = strings.ToUpper(s.prefix + "_" + key)
+	}
+
+	return s.replacer.Replace(name)
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	n := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(n, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNewLayered(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, dir, "config.yaml", "ddl:\n  rpc:\n    port: 1000\n    target: file\n    insecure: true\n")
+	writeFile(t, dir, "config.production.yaml", "ddl:\n  rpc:\n    port: 2000\n")
+	writeFile(t, dir, "other.toml", "[ddl.rpc]\ntarget = \"toml\"\n")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "search path",
+			args: []string{"config-path", dir},
+			want: map[string]string{"port": "1000", "target": "file"},
+		},
+		{
+			name: "environment overlay",
+			args: []string{"config-path", dir, "config-env", "production"},
+			want: map[string]string{"port": "2000", "target": "file"},
+		},
+		{
+			name: "environment variables win",
+			env:  map[string]string{"DDL_RPC_PORT": "3000"},
+			args: []string{"config-path", dir, "config-env", "production"},
+			want: map[string]string{"port": "3000", "target": "file"},
+		},
+		{
+			name: "files merge in order",
+			args: []string{"config-file", filepath.Join(dir, "config.yaml") + "," + filepath.Join(dir, "other.toml")},
+			want: map[string]string{"port": "1000", "target": "toml"},
+		},
+		{
+			name: "defaults from environment",
+			env:  map[string]string{"DDL_CONFIG_PATH": dir, "DDL_CONFIG_ENV": "production"},
+			want: map[string]string{"port": "2000"},
+		},
+		{
+			name: "section follows prefix",
+			args: []string{"config-path", dir, "env-prefix", "DDL_DB"},
+			want: map[string]string{"port": ""},
+		},
+		{
+			name:    "missing file",
+			args:    []string{"config-file", filepath.Join(dir, "missing.yaml")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			v, err := New("DDL_RPC", tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			for k, want := range tt.want {
+				if got := v.GetString(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
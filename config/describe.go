@@ -0,0 +1,187 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+)
+
+// Origin is where the effective value of a key came from.
+type Origin string
+
+// Origins, from lowest to highest precedence.
+const (
+	Unset   Origin = "unset"
+	Default Origin = "default"
+	File    Origin = "file"
+	Env     Origin = "env"
+	Flag    Origin = "flag"
+)
+
+// Entry describes the effective value of a single key.
+type Entry struct {
+	Component string `json:"component,omitempty"`
+	Key       string `json:"key"`
+	Env       string `json:"env"`
+	Origin    Origin `json:"origin"`
+	Value     string `json:"value"`
+}
+
+// Description is the effective configuration of one or more viper instances.
+type Description []Entry
+
+// WriteTable writes d as an aligned table.
+func (d Description) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tKEY\tENV\tORIGIN\tVALUE")
+	for _, e := range d {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Component, e.Key, e.Env, e.Origin, e.Value)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes d as a JSON array.
+func (d Description) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// consume records that f was read through Decode.
+func (s *source) consume(f field) {
+	for _, c := range s.consumed {
+		if c.key == f.key {
+			return
+		}
+	}
+	s.consumed = append(s.consumed, f)
+}
+
+// Describe reports every key of v that was read through Decode, or every key v knows of if none
+// were.  Secrets and resolved references are redacted.
+func Describe(v *viper.Viper) Description {
+	defer lock(v)()
+
+	s := sourceOf(v)
+
+	keys := s.consumed
+	if len(keys) == 0 {
+		for _, k := range v.AllKeys() {
+			keys = append(keys, field{key: k})
+		}
+	}
+
+	out := make(Description, 0, len(keys))
+	for _, c := range keys {
+		out = append(out, s.describe(v, c))
+	}
+
+	return out
+}
+
+func (s *source) describe(v *viper.Viper, c field) Entry {
+	e := Entry{
+		Key: c.key,
+		Env: s.envName(c.key),
+	}
+
+	f, flagged := s.flags[c.key]
+
+	switch {
+	case flagged && f.HasChanged():
+		e.Origin = Flag
+	case os.Getenv(e.Env) != "":
+		e.Origin = Env
+	case lookup(s.settings, c.key) != nil:
+		e.Origin = File
+	case c.def != "":
+		e.Origin = Default
+	default:
+		e.Origin = Unset
+	}
+
+	switch {
+	case e.Origin == Unset:
+	case e.Origin == Default:
+		e.Value = c.def
+	case s.resolved[c.key] != "":
+		e.Value = Redact(s.resolved[c.key])
+	case c.secret:
+		e.Value = Redact(v.GetString(c.key))
+	default:
+		e.Value = fmt.Sprint(v.Get(c.key))
+	}
+
+	return e
+}
+
+// lookup walks a nested settings map along a dotted key.
+func lookup(m map[string]interface{}, key string) interface{} {
+	path := strings.Split(key, ".")
+	for i, p := range path {
+		val, ok := m[p]
+		if !ok {
+			return nil
+		}
+		if i == len(path)-1 {
+			return val
+		}
+		if m, ok = val.(map[string]interface{}); !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+// registered holds the instance of every component, in the order they were first registered.
+var registered = struct {
+	sync.Mutex
+	components []string
+	vipers     map[string]*viper.Viper
+}{
+	vipers: make(map[string]*viper.Viper),
+}
+
+// Register records that component is configured by v, so that it is included by Effective.  Every
+// WithViper option in hugh registers itself.  Registering a component again replaces its instance.
+func Register(component string, v *viper.Viper) {
+	registered.Lock()
+	if _, ok := registered.vipers[component]; !ok {
+		registered.components = append(registered.components, component)
+	}
+	registered.vipers[component] = v
+	registered.Unlock()
+}
+
+// components returns the registered components and their instances, in order.
+func components() ([]string, []*viper.Viper) {
+	registered.Lock()
+	defer registered.Unlock()
+
+	vipers := make([]*viper.Viper, len(registered.components))
+	for i, c := range registered.components {
+		vipers[i] = registered.vipers[c]
+	}
+	return append([]string(nil), registered.components...), vipers
+}
+
+// Effective describes every registered component.
+func Effective() Description {
+	components, vipers := components()
+
+	var out Description
+	for i, v := range vipers {
+		for _, e := range Describe(v) {
+			e.Component = components[i]
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
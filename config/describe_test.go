@@ -0,0 +1,124 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeFile(t, dir, "config.yaml", "ddl:\n  test:\n    host: file.local\n    port: 1000\n")
+	key := writeFile(t, dir, "key", "hunter2")
+
+	os.Setenv("DDL_TEST_PORT", "2000")
+	os.Setenv("DDL_TEST_PASSWORD", "hunter2")
+	os.Setenv("DDL_TEST_TLS_KEY", "file://"+key)
+	defer os.Unsetenv("DDL_TEST_PORT")
+	defer os.Unsetenv("DDL_TEST_PASSWORD")
+	defer os.Unsetenv("DDL_TEST_TLS_KEY")
+
+	var c struct {
+		Host     string `config:"host"`
+		Port     int    `config:"port"`
+		Mode     string `config:"mode" default:"a"`
+		Name     string `config:"name"`
+		Password string `config:"password" secret:"true"`
+		Key      string `config:"tls-key"`
+	}
+
+	v, err := New("DDL_TEST", "config-file", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(v, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	Register("test", v)
+
+	want := Description{
+		{Key: "host", Env: "DDL_TEST_HOST", Origin: File, Value: "file.local"},
+		{Key: "port", Env: "DDL_TEST_PORT", Origin: Env, Value: "2000"},
+		{Key: "mode", Env: "DDL_TEST_MODE", Origin: Default, Value: "a"},
+		{Key: "name", Env: "DDL_TEST_NAME", Origin: Unset},
+		{Key: "password", Env: "DDL_TEST_PASSWORD", Origin: Env, Value: redacted},
+		{Key: "tls-key", Env: "DDL_TEST_TLS_KEY", Origin: Env, Value: "file://" + key},
+	}
+
+	if got := Describe(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe() = %+v, want %+v", got, want)
+	}
+
+	var found bool
+	for _, e := range Effective() {
+		if e.Component == "test" && e.Key == "host" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Effective() does not include registered component")
+	}
+
+	var buf bytes.Buffer
+	if err := Describe(v).WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("WriteTable() leaked a secret:\n%s", buf.String())
+	}
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		v, err := New("DDL_REPLACE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var c struct {
+			Host string `config:"host"`
+		}
+		if err := Decode(v, &c); err != nil {
+			t.Fatal(err)
+		}
+		Register("replace", v)
+	}
+
+	var n int
+	for _, e := range Effective() {
+		if e.Component == "replace" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("Effective() has %d entries for a component registered twice, want 1", n)
+	}
+}
+
+func TestSourcesForgotten(t *testing.T) {
+	v, err := New("DDL_FORGOTTEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := addr(v)
+	v = nil
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		sources.RLock()
+		_, ok := sources.m[a]
+		sources.RUnlock()
+		if !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("source of an unreachable instance was kept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
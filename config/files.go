@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const defaultName = "config"
+
+// extensions are the file types searched for, in order, when looking through config paths.
+var extensions = []string{"yaml", "yml", "toml", "json"}
+
+// files describes the file sources layered beneath the environment.
+type files struct {
+	names string
+	paths string
+	name  string
+	env   string
+}
+
+// resolve returns the ordered list of files to merge.  Explicitly named files must exist, while
+// searched paths are allowed to come up empty.
+func (f files) resolve() ([]string, error) {
+	var out []string
+
+	for _, n := range split(f.names) {
+		if _, err := os.Stat(n); err != nil {
+			return nil, fmt.Errorf("config file %q: %v", n, err)
+		}
+		out = append(out, n)
+	}
+
+	name := f.name
+	if name == "" {
+		name = defaultName
+	}
+
+	for _, p := range split(f.paths) {
+		if n, ok := find(p, name); ok {
+			out = append(out, n)
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// load merges each file and its environment overlay, returning the section that belongs to prefix.
+func (f files) load(prefix string) (map[string]interface{}, error) {
+	names, err := f.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := viper.New()
+
+	for _, n := range names {
+		if err := mergeFile(merged, n); err != nil {
+			return nil, err
+		}
+
+		if f.env == "" {
+			continue
+		}
+
+		if o := overlay(n, f.env); exists(o) {
+			if err := mergeFile(merged, o); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if section := sectionOf(prefix); section != "" {
+		if sub := merged.Sub(section); sub != nil {
+			return sub.AllSettings(), nil
+		}
+		return map[string]interface{}{}, nil
+	}
+
+	return merged.AllSettings(), nil
+}
+
+func mergeFile(v *viper.Viper, name string) error {
+	f := viper.New()
+	f.SetConfigFile(name)
+	if err := f.ReadInConfig(); err != nil {
+		return fmt.Errorf("config file %q: %v", name, err)
+	}
+	return v.MergeConfigMap(f.AllSettings())
+}
+
+// overlay returns the environment specific name for a file, e.g. config.yaml becomes config.production.yaml.
+func overlay(name, env string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + env + ext
+}
+
+// sectionOf maps an environment prefix onto a file section, e.g. DDL_RPC becomes ddl.rpc.
+func sectionOf(prefix string) string {
+	return strings.ToLower(strings.ReplaceAll(prefix, "_", "."))
+}
+
+func find(dir, name string) (string, bool) {
+	for _, ext := range extensions {
+		n := filepath.Join(dir, name+"."+ext)
+		if exists(n) {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+func exists(name string) bool {
+	st, err := os.Stat(name)
+	return err == nil && !st.IsDir()
+}
+
+func split(s string) []string {
+	var out []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Flags is a source of command line flags, made with PFlags or Kingpin.
+type Flags interface {
+	values() []viper.FlagValue
+}
+
+// PFlags returns the flags of fs.
+func PFlags(fs *pflag.FlagSet) Flags {
+	return pflagSet{fs}
+}
+
+// Kingpin returns the flags of a kingpin application.  It must be called before the application is
+// parsed.
+func Kingpin(app *kingpin.Application) Flags {
+	k := kingpinSet{
+		app:     app,
+		changed: make(map[string]bool),
+	}
+
+	app.PreAction(func(ctx *kingpin.ParseContext) error {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		for _, e := range ctx.Elements {
+			if f, ok := e.Clause.(*kingpin.FlagClause); ok {
+				k.changed[f.Model().Name] = true
+			}
+		}
+		return nil
+	})
+
+	return &k
+}
+
+// bound holds the flags of BindFlags and BindKingpin, for instances built with config-flags.
+var bound = struct {
+	sync.Mutex
+	sets []Flags
+}{}
+
+func bind(f Flags) {
+	bound.Lock()
+	bound.sets = append(bound.sets, f)
+	bound.Unlock()
+}
+
+// BindFlags makes the flags in fs available to instances built by New with "config-flags", "true", or
+// with DDL_CONFIG_FLAGS=true, such as server.WithViper("config-flags", "true").  Other instances
+// ignore them.
+func BindFlags(fs *pflag.FlagSet) {
+	bind(PFlags(fs))
+}
+
+// BindKingpin makes the flags of a kingpin application available the same way as BindFlags.  It must
+// be called before the application is parsed.
+func BindKingpin(app *kingpin.Application) {
+	bind(Kingpin(app))
+}
+
+// bindFlags binds every flag under the section of the prefix.
+func (s *source) bindFlags(v *viper.Viper) error {
+	sets := s.flagSets
+	if s.boundFlags {
+		bound.Lock()
+		sets = append(append([]Flags(nil), sets...), bound.sets...)
+		bound.Unlock()
+	}
+
+	section := sectionOf(s.prefix)
+	if section != "" {
+		section += "."
+	}
+
+	for _, fs := range sets {
+		for _, f := range fs.values() {
+			if !strings.HasPrefix(f.Name(), section) {
+				continue
+			}
+
+			key := strings.ToLower(strings.TrimPrefix(f.Name(), section))
+			if err := v.BindFlagValue(key, f); err != nil {
+				return err
+			}
+			s.flags[key] = f
+		}
+	}
+
+	return nil
+}
+
+type pflagSet struct {
+	fs *pflag.FlagSet
+}
+
+func (p pflagSet) values() []viper.FlagValue {
+	var out []viper.FlagValue
+	p.fs.VisitAll(func(f *pflag.Flag) {
+		out = append(out, pflagValue{f})
+	})
+	return out
+}
+
+type pflagValue struct {
+	f *pflag.Flag
+}
+
+func (p pflagValue) HasChanged() bool    { return p.f.Changed }
+func (p pflagValue) Name() string        { return p.f.Name }
+func (p pflagValue) ValueString() string { return p.f.Value.String() }
+func (p pflagValue) ValueType() string   { return p.f.Value.Type() }
+
+type kingpinSet struct {
+	app     *kingpin.Application
+	mu      sync.Mutex
+	changed map[string]bool
+}
+
+func (k *kingpinSet) values() []viper.FlagValue {
+	var out []viper.FlagValue
+	for _, f := range k.app.Model().Flags {
+		out = append(out, kingpinValue{k: k, f: f})
+	}
+	return out
+}
+
+type kingpinValue struct {
+	k *kingpinSet
+	f *kingpin.FlagModel
+}
+
+func (v kingpinValue) HasChanged() bool {
+	v.k.mu.Lock()
+	defer v.k.mu.Unlock()
+	return v.k.changed[v.f.Name]
+}
+
+func (v kingpinValue) Name() string        { return v.f.Name }
+func (v kingpinValue) ValueString() string { return v.f.Value.String() }
+func (v kingpinValue) ValueType() string   { return "string" }
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestFlagPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeFile(t, dir, "config.yaml", "ddl:\n  flags:\n    file: file\n    env: file\n    flag: file\n")
+
+	os.Setenv("DDL_FLAGS_ENV", "env")
+	os.Setenv("DDL_FLAGS_FLAG", "env")
+	defer os.Unsetenv("DDL_FLAGS_ENV")
+	defer os.Unsetenv("DDL_FLAGS_FLAG")
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("ddl.flags.flag", "", "")
+	fs.String("ddl.flags.unchanged", "", "")
+
+	app := kingpin.New("test", "")
+	app.Flag("ddl.flags.kingpin", "").String()
+	BindKingpin(app)
+
+	if err := fs.Parse([]string{"--ddl.flags.flag=flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Parse([]string{"--ddl.flags.kingpin=kingpin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var c struct {
+		Default   string `config:"default" default:"default"`
+		File      string `config:"file" default:"default"`
+		Env       string `config:"env" default:"default"`
+		Flag      string `config:"flag" default:"default"`
+		Unchanged string `config:"unchanged" default:"default"`
+		Kingpin   string `config:"kingpin"`
+	}
+
+	v, err := NewWithFlags("DDL_FLAGS", PFlags(fs), "config-file", cfg, "config-flags", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(v, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct {
+		value  string
+		origin Origin
+	}{
+		"default":   {"default", Default},
+		"file":      {"file", File},
+		"env":       {"env", Env},
+		"flag":      {"flag", Flag},
+		"unchanged": {"default", Default},
+		"kingpin":   {"kingpin", Flag},
+	}
+
+	for _, e := range Describe(v) {
+		w := want[e.Key]
+		if e.Value != w.value || e.Origin != w.origin {
+			t.Errorf("%s = %q from %s, want %q from %s", e.Key, e.Value, e.Origin, w.value, w.origin)
+		}
+	}
+}
+
+func TestBoundFlagsOptIn(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("ddl.optin.port", "", "")
+	BindFlags(fs)
+
+	if err := fs.Parse([]string{"--ddl.optin.port=9000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "ignored by default",
+		},
+		{
+			name: "config-flags",
+			args: []string{"config-flags", "true"},
+			want: "9000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := New("DDL_OPTIN", tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.GetString("port"); got != tt.want {
+				t.Errorf("port = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Load fills the tagged struct pointed to by dst using a viper instance constructed by New.  See Decode
+// for the supported tags.
+func Load(prefix string, dst interface{}, args ...string) error {
+	v, err := New(prefix, args...)
+	if err != nil {
+		return err
+	}
+
+	return Decode(v, dst)
+}
+
+// Decode fills the tagged struct pointed to by dst from v.
+//
+// Fields are bound with the following tags:
+// * config - the viper key.  Fields without it are ignored.
+// * env - binds the key to a specific environment variable instead of the prefixed default.
+// * default - the value used when the key is not set.
+// * required - "true" if the key must be set.
+// * enum - a comma separated list of allowed values.
+// * min, max - inclusive bounds for numeric and duration fields.
+// * secret - "true" if the value must be redacted when described.
+// * desc - a description of the key, used by Schema.
+//
+// Values that are references are resolved before they are converted.  Fields whose key is neither
+// set nor defaulted are left untouched, so dst may be pre-populated.
+// Every offending key is reported in a single LoadError.
+func Decode(v *viper.Viper, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: Decode requires a pointer to a struct, got %T", dst)
+	}
+
+	defer lock(v)()
+
+	s := sourceOf(v)
+	fields := fieldsOf(rv.Elem().Type())
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if err := v.BindEnv(f.key, f.env); err != nil {
+			return err
+		}
+		s.bindings[f.key] = f.env
+	}
+
+	return s.decode(v, rv.Elem(), fields)
+}
+
+// FieldError describes a single key that failed to load.
+type FieldError struct {
+	Key    string
+	Env    string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", e.Key, e.Env, e.Reason)
+}
+
+// LoadError aggregates every key that failed to load.
+type LoadError []FieldError
+
+func (e LoadError) Error() string {
+	s := make([]string, len(e))
+	for i, f := range e {
+		s[i] = f.Error()
+	}
+	return "invalid configuration: " + strings.Join(s, "; ")
+}
+
+// field is a parsed struct field.
+type field struct {
+	index    int
+	typ      string
+	desc     string
+	key      string
+	env      string
+	def      string
+	required bool
+	enum     []string
+	min      string
+	max      string
+	secret   bool
+}
+
+func fieldsOf(t reflect.Type) []field {
+	var out []field
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("config")
+		if key == "" || sf.PkgPath != "" {
+			continue
+		}
+
+		f := field{
+			index: i,
+			typ:   typeName(sf.Type),
+			desc:  sf.Tag.Get("desc"),
+			key:   strings.ToLower(key),
+			env:   sf.Tag.Get("env"),
+			def:   sf.Tag.Get("default"),
+			min:   sf.Tag.Get("min"),
+			max:   sf.Tag.Get("max"),
+		}
+
+		f.required, _ = strconv.ParseBool(sf.Tag.Get("required"))
+		f.secret, _ = strconv.ParseBool(sf.Tag.Get("secret"))
+
+		if e := sf.Tag.Get("enum"); e != "" {
+			f.enum = split(e)
+		}
+
+		out = append(out, f)
+	}
+
+	return out
+}
+
+func (s *source) decode(v *viper.Viper, dst reflect.Value, fields []field) error {
+	var errs LoadError
+
+	for _, f := range fields {
+		s.consume(f)
+
+		fail := func(format string, a ...interface{}) {
+			errs = append(errs, FieldError{
+				Key:    f.key,
+				Env:    s.envName(f.key),
+				Reason: fmt.Sprintf(format, a...),
+			})
+		}
+
+		if err := s.resolve(v, f.key); err != nil {
+			fail("%v", err)
+			continue
+		}
+
+		var raw interface{}
+		switch {
+		case v.IsSet(f.key):
+			raw = v.Get(f.key)
+		case f.def != "":
+			raw = f.def
+		case f.required:
+			fail("required")
+			continue
+		default:
+			continue
+		}
+
+		fv := dst.Field(f.index)
+
+		val, err := convert(raw, fv.Type())
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+
+		if err := f.validate(val); err != nil {
+			fail("%v", err)
+			continue
+		}
+
+		fv.Set(val)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// typeName returns the name a type is documented as.
+func typeName(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return "duration"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		return "int"
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		return "uint"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "float"
+	case t.Kind() == reflect.Slice:
+		return "list"
+	default:
+		return t.Kind().String()
+	}
+}
+
+func convert(raw interface{}, t reflect.Type) (reflect.Value, error) {
+	var (
+		out interface{}
+		err error
+	)
+
+	switch {
+	case t == durationType:
+		out, err = cast.ToDurationE(raw)
+	case t.Kind() == reflect.String:
+		out, err = cast.ToStringE(raw)
+	case t.Kind() == reflect.Bool:
+		out, err = cast.ToBoolE(raw)
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		out, err = cast.ToInt64E(raw)
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		out, err = cast.ToUint64E(raw)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		out, err = cast.ToFloat64E(raw)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		if s, ok := raw.(string); ok {
+			out = split(s)
+		} else {
+			out, err = cast.ToStringSliceE(raw)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported type %s", t)
+	}
+
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("invalid %s value %q", t, fmt.Sprint(raw))
+	}
+
+	return reflect.ValueOf(out).Convert(t), nil
+}
+
+func (f field) validate(val reflect.Value) error {
+	if len(f.enum) > 0 {
+		s := fmt.Sprint(val.Interface())
+		ok := false
+		for _, e := range f.enum {
+			if s == e {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("invalid value %q, valid values [%s]", s, strings.Join(f.enum, ", "))
+		}
+	}
+
+	if f.min == "" && f.max == "" {
+		return nil
+	}
+
+	n, err := number(val)
+	if err != nil {
+		return err
+	}
+
+	if f.min != "" {
+		bound, err := convert(f.min, val.Type())
+		if err != nil {
+			return fmt.Errorf("invalid min tag: %v", err)
+		}
+		if b, _ := number(bound); n < b {
+			return fmt.Errorf("%v is less than the minimum %v", val.Interface(), bound.Interface())
+		}
+	}
+
+	if f.max != "" {
+		bound, err := convert(f.max, val.Type())
+		if err != nil {
+			return fmt.Errorf("invalid max tag: %v", err)
+		}
+		if b, _ := number(bound); n > b {
+			return fmt.Errorf("%v is greater than the maximum %v", val.Interface(), bound.Interface())
+		}
+	}
+
+	return nil
+}
+
+func number(val reflect.Value) (float64, error) {
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), nil
+	default:
+		return 0, fmt.Errorf("min and max are not supported for %s", val.Type())
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type loadTest struct {
+	Name     string        `config:"name" required:"true"`
+	Mode     string        `config:"mode" enum:"a,b" default:"a"`
+	Port     int           `config:"port" min:"1" max:"65535"`
+	Timeout  time.Duration `config:"timeout" default:"5s"`
+	Hosts    []string      `config:"hosts"`
+	Debug    bool          `config:"debug" env:"TEST_DEBUG"`
+	Untagged string
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		start   loadTest
+		want    loadTest
+		wantErr []string
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"DDL_TEST_NAME": "hugh"},
+			want: loadTest{Name: "hugh", Mode: "a", Timeout: 5 * time.Second},
+		},
+		{
+			name: "all set",
+			env: map[string]string{
+				"DDL_TEST_NAME":    "hugh",
+				"DDL_TEST_MODE":    "b",
+				"DDL_TEST_PORT":    "8080",
+				"DDL_TEST_TIMEOUT": "1m",
+				"DDL_TEST_HOSTS":   "a,b",
+				"TEST_DEBUG":       "true",
+			},
+			want: loadTest{Name: "hugh", Mode: "b", Port: 8080, Timeout: time.Minute, Hosts: []string{"a", "b"}, Debug: true},
+		},
+		{
+			name:  "unset keys keep their value",
+			env:   map[string]string{"DDL_TEST_NAME": "hugh"},
+			start: loadTest{Port: 9000, Untagged: "x"},
+			want:  loadTest{Name: "hugh", Mode: "a", Port: 9000, Timeout: 5 * time.Second, Untagged: "x"},
+		},
+		{
+			name: "every error is reported",
+			env: map[string]string{
+				"DDL_TEST_MODE":    "c",
+				"DDL_TEST_PORT":    "70000",
+				"DDL_TEST_TIMEOUT": "soon",
+			},
+			wantErr: []string{"DDL_TEST_NAME", "DDL_TEST_MODE", "DDL_TEST_PORT", "DDL_TEST_TIMEOUT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			got := tt.start
+			err := Load("DDL_TEST", &got)
+
+			if tt.wantErr != nil {
+				lerr, ok := err.(LoadError)
+				if !ok {
+					t.Fatalf("Load() error = %v, want LoadError", err)
+				}
+				var envs []string
+				for _, f := range lerr {
+					envs = append(envs, f.Env)
+				}
+				if !reflect.DeepEqual(envs, tt.wantErr) {
+					t.Fatalf("Load() errors = %v, want %v", envs, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
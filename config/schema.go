@@ -0,0 +1,254 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Key documents a single configuration key.
+type Key struct {
+	Component   string   `json:"component"`
+	Key         string   `json:"key"`
+	Env         string   `json:"env"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Secret      bool     `json:"secret,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Min         string   `json:"min,omitempty"`
+	Max         string   `json:"max,omitempty"`
+}
+
+// Keys is a list of documented keys, as returned by Schema.
+type Keys []Key
+
+type declaration struct {
+	component string
+	src       *source
+	fields    []field
+}
+
+var declared = struct {
+	sync.Mutex
+	d []declaration
+}{}
+
+// Declare documents the keys of the tagged struct spec, read by component using New(prefix, args...).
+// Every package in hugh declares its keys with their default prefix when it is initialized.
+func Declare(component, prefix string, spec interface{}, args ...string) {
+	t := reflect.TypeOf(spec)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	declared.Lock()
+	declared.d = append(declared.d, declaration{
+		component: component,
+		src:       parse(prefix, args...),
+		fields:    fieldsOf(t),
+	})
+	declared.Unlock()
+}
+
+// Schema returns every declared key.  Once a component has been configured, the keys it registered
+// replace its declaration, so custom env-prefix, env-replace and binding args are reflected.
+func Schema() Keys {
+	runtime := make(map[string][]declaration)
+
+	order, vipers := components()
+	for i, c := range order {
+		runtime[c] = []declaration{{
+			component: c,
+			src:       sourceOf(vipers[i]),
+			fields:    sourceOf(vipers[i]).consumed,
+		}}
+	}
+
+	var decls []declaration
+	used := make(map[string]bool)
+
+	declared.Lock()
+	for _, d := range declared.d {
+		switch r, ok := runtime[d.component]; {
+		case !ok:
+			decls = append(decls, d)
+		case !used[d.component]:
+			decls = append(decls, r...)
+			used[d.component] = true
+		}
+	}
+	declared.Unlock()
+
+	for _, c := range order {
+		if !used[c] {
+			decls = append(decls, runtime[c]...)
+		}
+	}
+
+	var out Keys
+	dup := make(map[string]bool)
+
+	for _, d := range decls {
+		for _, f := range d.fields {
+			k := Key{
+				Component:   d.component,
+				Key:         f.key,
+				Env:         d.src.envName(f.key),
+				Type:        f.typ,
+				Description: f.desc,
+				Default:     f.def,
+				Required:    f.required,
+				Secret:      f.secret,
+				Enum:        f.enum,
+				Min:         f.min,
+				Max:         f.max,
+			}
+
+			if id := k.Component + "/" + k.Env; !dup[id] {
+				dup[id] = true
+				out = append(out, k)
+			}
+		}
+	}
+
+	return out
+}
+
+// WriteMarkdown writes k as a table per component.
+func (k Keys) WriteMarkdown(w io.Writer) error {
+	var component string
+
+	for i, key := range k {
+		if i == 0 || key.Component != component {
+			component = key.Component
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "### %s\n\n", component)
+			fmt.Fprintln(w, "| Environment Variable | Type | Description | Default | Required |")
+			fmt.Fprintln(w, "| ------------ | ------------ | ------------ | ------------ | ------------ |")
+		}
+
+		req := ""
+		if key.Required {
+			req = "yes"
+		}
+
+		if _, err := fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n", key.Env, key.Type, key.describe(), key.Default, req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteEnv writes k as an example .env file.  Required keys are left uncommented.
+func (k Keys) WriteEnv(w io.Writer) error {
+	var component string
+
+	for i, key := range k {
+		if i == 0 || key.Component != component {
+			component = key.Component
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "# %s\n", component)
+		}
+
+		if d := key.describe(); d != "" {
+			fmt.Fprintf(w, "# %s\n", d)
+		}
+
+		comment := "# "
+		if key.Required {
+			comment = ""
+		}
+
+		if _, err := fmt.Fprintf(w, "%s%s=%s\n", comment, key.Env, key.Default); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteJSONSchema writes k as a JSON Schema describing an object of environment variables, suitable for
+// validating the environment of a deployment.  Values are strings, constrained by pattern or enum.
+func (k Keys) WriteJSONSchema(w io.Writer) error {
+	type property struct {
+		Type        string   `json:"type"`
+		Description string   `json:"description,omitempty"`
+		Default     string   `json:"default,omitempty"`
+		Enum        []string `json:"enum,omitempty"`
+		Pattern     string   `json:"pattern,omitempty"`
+	}
+
+	schema := struct {
+		Schema     string              `json:"$schema"`
+		Type       string              `json:"type"`
+		Properties map[string]property `json:"properties"`
+		Required   []string            `json:"required,omitempty"`
+	}{
+		Schema:     "http://json-schema.org/draft-07/schema#",
+		Type:       "object",
+		Properties: make(map[string]property),
+	}
+
+	for _, key := range k {
+		schema.Properties[key.Env] = property{
+			Type:        "string",
+			Description: key.describe(),
+			Default:     key.Default,
+			Enum:        key.Enum,
+			Pattern:     patterns[key.Type],
+		}
+		if key.Required {
+			schema.Required = append(schema.Required, key.Env)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
+
+// patterns constrain the string form of typed values.
+var patterns = map[string]string{
+	"bool":     "^(1|0|t|f|T|F|true|false|TRUE|FALSE|True|False)$",
+	"int":      "^-?[0-9]+$",
+	"uint":     "^[0-9]+$",
+	"float":    "^-?[0-9]*\\.?[0-9]+([eE][-+]?[0-9]+)?$",
+	"duration": "^(-?[0-9]+|(-?[0-9]*\\.?[0-9]+(ns|us|µs|ms|s|m|h))+)$",
+}
+
+// describe returns the description of k along with any constraints.
+func (k Key) describe() string {
+	var c []string
+	if len(k.Enum) > 0 {
+		c = append(c, "one of "+strings.Join(k.Enum, ", "))
+	}
+	if k.Min != "" {
+		c = append(c, "min "+k.Min)
+	}
+	if k.Max != "" {
+		c = append(c, "max "+k.Max)
+	}
+	if k.Secret {
+		c = append(c, "secret")
+	}
+
+	if len(c) == 0 {
+		return k.Description
+	}
+
+	s := "(" + strings.Join(c, ", ") + ")"
+	if k.Description == "" {
+		return s
+	}
+	return k.Description + " " + s
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type schemaTest struct {
+	Host     string `config:"host" required:"true" desc:"Host to connect to"`
+	Port     int    `config:"port" default:"80" min:"1" max:"65535"`
+	Password string `config:"password" secret:"true"`
+}
+
+func schemaOf(component string) Keys {
+	var out Keys
+	for _, k := range Schema() {
+		if k.Component == component {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// cleanupComponent removes the declaration and registration of component when the test ends, so that
+// reruns start from scratch.
+func cleanupComponent(t *testing.T, component string) {
+	t.Cleanup(func() {
+		declared.Lock()
+		d := declared.d[:0]
+		for _, decl := range declared.d {
+			if decl.component != component {
+				d = append(d, decl)
+			}
+		}
+		declared.d = d
+		declared.Unlock()
+
+		registered.Lock()
+		if _, ok := registered.vipers[component]; ok {
+			delete(registered.vipers, component)
+			c := registered.components[:0]
+			for _, name := range registered.components {
+				if name != component {
+					c = append(c, name)
+				}
+			}
+			registered.components = c
+		}
+		registered.Unlock()
+	})
+}
+
+func TestSchema(t *testing.T) {
+	cleanupComponent(t, "schema")
+	Declare("schema", "DDL_SCHEMA", schemaTest{})
+
+	keys := schemaOf("schema")
+	if len(keys) != 3 || keys[0].Env != "DDL_SCHEMA_HOST" || keys[1].Type != "int" || !keys[2].Secret {
+		t.Fatalf("Schema() = %+v", keys)
+	}
+
+	var env bytes.Buffer
+	if err := keys.WriteEnv(&env); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# schema\n",
+		"# Host to connect to\nDDL_SCHEMA_HOST=\n",
+		"# (min 1, max 65535)\n# DDL_SCHEMA_PORT=80\n",
+	} {
+		if !strings.Contains(env.String(), want) {
+			t.Errorf("WriteEnv() missing %q:\n%s", want, env.String())
+		}
+	}
+
+	var md bytes.Buffer
+	if err := keys.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md.String(), "| DDL_SCHEMA_HOST | string | Host to connect to |  | yes |") {
+		t.Errorf("WriteMarkdown() =\n%s", md.String())
+	}
+
+	var js bytes.Buffer
+	if err := keys.WriteJSONSchema(&js); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Pattern string `json:"pattern"`
+		} `json:"properties"`
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(js.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Properties["DDL_SCHEMA_PORT"].Pattern == "" || len(schema.Required) != 1 {
+		t.Errorf("WriteJSONSchema() =\n%s", js.String())
+	}
+
+	// Once configured, the registered keys replace the declaration.
+	v, err := New("DDL_SCHEMA", "env-prefix", "CUSTOM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c schemaTest
+	_ = Decode(v, &c)
+	Register("schema", v)
+
+	keys = schemaOf("schema")
+	if len(keys) != 3 || keys[0].Env != "CUSTOM_HOST" {
+		t.Fatalf("Schema() after Register = %+v", keys)
+	}
+}
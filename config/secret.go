@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Reference schemes understood by Resolve.
+const (
+	fileScheme   = "file://"
+	envScheme    = "env://"
+	base64Scheme = "base64:"
+)
+
+// redacted replaces secret values in logs.
+const redacted = "[REDACTED]"
+
+// IsReference reports whether s points at a value rather than being one.
+func IsReference(s string) bool {
+	return strings.HasPrefix(s, fileScheme) ||
+		strings.HasPrefix(s, envScheme) ||
+		strings.HasPrefix(s, base64Scheme)
+}
+
+// Resolve returns the value a reference points to:
+// * file:///run/secrets/tls.key - the contents of the file, without trailing newlines
+// * env://OTHER_VAR - the value of another environment variable
+// * base64:... - the decoded data
+//
+// Anything else is returned unchanged.
+func Resolve(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, fileScheme):
+		name := strings.TrimPrefix(s, fileScheme)
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(s, envScheme):
+		name := strings.TrimPrefix(s, envScheme)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return val, nil
+	case strings.HasPrefix(s, base64Scheme):
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, base64Scheme))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return s, nil
+	}
+}
+
+// Redact returns a form of s that is safe to log.  File and environment references only name where
+// a secret lives, so they are returned as is.
+func Redact(s string) string {
+	switch {
+	case s == "":
+		return ""
+	case strings.HasPrefix(s, fileScheme), strings.HasPrefix(s, envScheme):
+		return s
+	case strings.HasPrefix(s, base64Scheme):
+		return base64Scheme + redacted
+	default:
+		return redacted
+	}
+}
+
+// resolve replaces a reference stored under key with the value it points to.  The replacement is set
+// as a viper override, so it is removed with unresolve before the sources are re-read.
+func (s *source) resolve(v *viper.Viper, key string) error {
+	if _, ok := s.resolved[key]; ok {
+		return nil
+	}
+
+	raw, ok := v.Get(key).(string)
+	if !ok || !IsReference(raw) {
+		return nil
+	}
+
+	val, err := Resolve(raw)
+	if err != nil {
+		return err
+	}
+
+	s.resolved[key] = raw
+	v.Set(key, val)
+
+	return nil
+}
+
+// resolveAll resolves every key v knows of, along with keys derived from prefixed environment variables.
+func (s *source) resolveAll(v *viper.Viper) error {
+	var errs LoadError
+
+	for _, key := range s.keys(v) {
+		if err := s.resolve(v, key); err != nil {
+			errs = append(errs, FieldError{
+				Key:    key,
+				Env:    s.envName(key),
+				Reason: err.Error(),
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (s *source) unresolve(v *viper.Viper) {
+	for key := range s.resolved {
+		v.Set(key, nil)
+		delete(s.resolved, key)
+	}
+}
+
+// keys returns the keys set in files or bound to environment variables, and the kebab-case keys of
+// environment variables carrying the prefix.
+func (s *source) keys(v *viper.Viper) []string {
+	seen := make(map[string]bool)
+	var out []string
+
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			out = append(out, k)
+		}
+	}
+
+	for _, k := range v.AllKeys() {
+		add(k)
+	}
+
+	if s.prefix == "" {
+		return out
+	}
+
+	p := strings.ToUpper(s.prefix) + "_"
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, p) && len(name) > len(p) {
+			add(strings.ReplaceAll(strings.ToLower(name[len(p):]), "_", "-"))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,362 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// settle is how long the watcher waits for file events to stop before reloading, so that a
+// file is not read half written.
+const settle = 100 * time.Millisecond
+
+// ChangeFunc is called with the previous and current value of a key after a reload.
+type ChangeFunc func(old, new interface{})
+
+// Watcher re-reads the file sources of a viper instance when they change on disk or when the
+// process receives SIGHUP, and notifies subscribers of every key whose value changed.
+//
+// Environment variables are consulted by viper on every read, so a reload only needs to re-read
+// the files and resolve references again.
+//
+// A reload updates the instance in place, so reads that may run concurrently with one must go
+// through Read or Decode, which hold the watcher's lock.
+type Watcher struct {
+	v      *viper.Viper
+	state  sync.RWMutex
+	src    *source
+	fs     *fsnotify.Watcher
+	sig    chan os.Signal
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	hooks  map[string][]ChangeFunc
+	errFns []func(error)
+}
+
+var watchers = struct {
+	sync.Mutex
+	m map[*viper.Viper]*Watcher
+}{
+	m: make(map[*viper.Viper]*Watcher),
+}
+
+var globalHooks = struct {
+	sync.RWMutex
+	m map[string][]ChangeFunc
+}{
+	m: make(map[string][]ChangeFunc),
+}
+
+// OnChange registers fn against key on every Watcher, including those created later.  When a Watcher
+// starts, fn is also called with a nil old value if key is already set, so that settings present at
+// startup take effect.
+func OnChange(key string, fn ChangeFunc) {
+	key = strings.ToLower(key)
+	globalHooks.Lock()
+	globalHooks.m[key] = append(globalHooks.m[key], fn)
+	globalHooks.Unlock()
+}
+
+// Watch starts watching v for changes.  Calling Watch on an instance that is already being
+// watched returns the existing Watcher.
+func Watch(v *viper.Viper) (*Watcher, error) {
+	w, started, err := watch(v)
+	if err != nil {
+		return nil, err
+	}
+	if started {
+		w.applyGlobal()
+	}
+	return w, nil
+}
+
+// watch returns the Watcher of v, starting one if v isn't watched yet.
+func watch(v *viper.Viper) (*Watcher, bool, error) {
+	watchers.Lock()
+	defer watchers.Unlock()
+
+	if w, ok := watchers.m[v]; ok {
+		return w, false, nil
+	}
+
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, false, err
+	}
+
+	w := Watcher{
+		v:     v,
+		src:   sourceOf(v),
+		fs:    fs,
+		sig:   make(chan os.Signal, 1),
+		done:  make(chan struct{}),
+		hooks: make(map[string][]ChangeFunc),
+	}
+
+	for _, d := range w.src.files.dirs() {
+		if err := fs.Add(d); err != nil {
+			_ = fs.Close()
+			return nil, false, err
+		}
+	}
+
+	signal.Notify(w.sig, syscall.SIGHUP)
+
+	go w.run()
+
+	watchers.m[v] = &w
+
+	return &w, true, nil
+}
+
+// applyGlobal calls the hooks registered with OnChange for every key that is already set.
+func (w *Watcher) applyGlobal() {
+	var changes []change
+
+	globalHooks.RLock()
+	w.Read(func(v *viper.Viper) {
+		for k, fns := range globalHooks.m {
+			if cur := v.Get(k); cur != nil {
+				changes = append(changes, change{fns: append([]ChangeFunc(nil), fns...), new: cur})
+			}
+		}
+	})
+	globalHooks.RUnlock()
+
+	for _, c := range changes {
+		for _, fn := range c.fns {
+			fn(c.old, c.new)
+		}
+	}
+}
+
+// Watching returns the Watcher for v if it is being watched.
+func Watching(v *viper.Viper) (*Watcher, bool) {
+	watchers.Lock()
+	w, ok := watchers.m[v]
+	watchers.Unlock()
+	return w, ok
+}
+
+// Viper returns the watched instance.  Reading it directly races with reloads; use Read or
+// Decode instead.
+func (w *Watcher) Viper() *viper.Viper {
+	return w.v
+}
+
+// Read calls fn with the watched instance while no reload is in progress.  fn must not modify
+// the instance.
+func (w *Watcher) Read(fn func(v *viper.Viper)) {
+	w.state.RLock()
+	defer w.state.RUnlock()
+	fn(w.v)
+}
+
+// lock blocks reloads of v, if it is being watched, until the returned function is called.
+func lock(v *viper.Viper) func() {
+	w, ok := Watching(v)
+	if !ok {
+		return func() {}
+	}
+	w.state.Lock()
+	return w.state.Unlock
+}
+
+// OnChange registers fn to be called whenever the value of key changes.
+func (w *Watcher) OnChange(key string, fn ChangeFunc) {
+	key = strings.ToLower(key)
+	w.mu.Lock()
+	w.hooks[key] = append(w.hooks[key], fn)
+	w.mu.Unlock()
+}
+
+// OnError registers fn to be called when a background reload fails.  Without any, errors are
+// written to stderr.
+func (w *Watcher) OnError(fn func(error)) {
+	w.mu.Lock()
+	w.errFns = append(w.errFns, fn)
+	w.mu.Unlock()
+}
+
+// Reload re-reads the file sources and calls the hooks of every key that changed.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+
+	hooks := make(map[string][]ChangeFunc)
+	for k, fns := range w.hooks {
+		hooks[k] = append(hooks[k], fns...)
+	}
+	globalHooks.RLock()
+	for k, fns := range globalHooks.m {
+		hooks[k] = append(hooks[k], fns...)
+	}
+	globalHooks.RUnlock()
+
+	settings, err := w.src.files.load(w.src.prefix)
+	if err != nil {
+		w.mu.Unlock()
+		return err
+	}
+
+	w.state.Lock()
+	changes, err := w.reload(hooks, settings)
+	w.state.Unlock()
+	w.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		for _, fn := range c.fns {
+			fn(c.old, c.new)
+		}
+	}
+
+	return nil
+}
+
+type change struct {
+	fns      []ChangeFunc
+	old, new interface{}
+}
+
+// reload replaces the file layer of the instance with settings and returns the changed keys
+// among those in hooks.  The caller holds w.state.
+func (w *Watcher) reload(hooks map[string][]ChangeFunc, settings map[string]interface{}) ([]change, error) {
+	old := make(map[string]interface{}, len(hooks))
+	for k := range hooks {
+		old[k] = w.v.Get(k)
+	}
+
+	w.src.unresolve(w.v)
+
+	if err := reset(w.v, settings); err != nil {
+		return nil, err
+	}
+	w.src.settings = settings
+
+	if err := w.src.resolveAll(w.v); err != nil {
+		return nil, err
+	}
+
+	var changes []change
+	for k, fns := range hooks {
+		if cur := w.v.Get(k); !reflect.DeepEqual(old[k], cur) {
+			changes = append(changes, change{fns: fns, old: old[k], new: cur})
+		}
+	}
+
+	return changes, nil
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	var err error
+
+	w.once.Do(func() {
+		watchers.Lock()
+		delete(watchers.m, w.v)
+		watchers.Unlock()
+
+		signal.Stop(w.sig)
+		close(w.done)
+		err = w.fs.Close()
+	})
+
+	return err
+}
+
+func (w *Watcher) run() {
+	t := time.NewTimer(settle)
+	t.Stop()
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.sig:
+			w.report(w.Reload())
+		case <-t.C:
+			w.report(w.Reload())
+		case ev, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&fsnotify.Chmod == ev.Op {
+				continue
+			}
+			t.Reset(settle)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			w.report(err)
+		}
+	}
+}
+
+func (w *Watcher) report(err error) {
+	if err == nil {
+		return
+	}
+
+	w.mu.Lock()
+	fns := w.errFns
+	w.mu.Unlock()
+
+	if len(fns) == 0 {
+		fmt.Fprintf(os.Stderr, "config: reload failed: %v\n", err)
+		return
+	}
+
+	for _, fn := range fns {
+		fn(err)
+	}
+}
+
+// reset replaces the file layer of v with settings.
+func reset(v *viper.Viper, settings map[string]interface{}) error {
+	v.SetConfigType("json")
+	if err := v.ReadConfig(strings.NewReader("{}")); err != nil {
+		return err
+	}
+	return v.MergeConfigMap(settings)
+}
+
+// dirs returns the directories that may hold file sources.  Directories are watched rather than
+// files so that atomic replacements, such as kubernetes ConfigMap updates, are seen.
+func (f files) dirs() []string {
+	seen := make(map[string]bool)
+	var out []string
+
+	add := func(d string) {
+		d = filepath.Clean(d)
+		if st, err := os.Stat(d); err != nil || !st.IsDir() || seen[d] {
+			return
+		}
+		seen[d] = true
+		out = append(out, d)
+	}
+
+	for _, n := range split(f.names) {
+		add(filepath.Dir(n))
+	}
+
+	for _, p := range split(f.paths) {
+		add(p)
+	}
+
+	return out
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "config.yaml", "ddl:\n  rpc:\n    port: 1000\n")
+
+	v, err := New("DDL_RPC", "config-file", name, "config-watch", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, ok := Watching(v)
+	if !ok {
+		t.Fatal("config-watch did not start a watcher")
+	}
+	defer w.Close()
+
+	type change struct{ old, new interface{} }
+	changes := make(chan change, 10)
+
+	w.OnChange("port", func(old, new interface{}) {
+		changes <- change{old, new}
+	})
+	w.OnChange("target", func(old, new interface{}) {
+		t.Errorf("target changed from %v to %v", old, new)
+	})
+
+	writeFile(t, dir, filepath.Base(name), "ddl:\n  rpc:\n    port: 2000\n")
+
+	select {
+	case c := <-changes:
+		if c.old != 1000 || c.new != 2000 {
+			t.Errorf("change = %v -> %v, want 1000 -> 2000", c.old, c.new)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+
+	if got := v.GetInt("port"); got != 2000 {
+		t.Errorf("port = %d, want 2000", got)
+	}
+}
+
+func TestWatchReadDuringReload(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "config.yaml", "ddl:\n  rpc:\n    port: 1000\n")
+
+	v, err := New("DDL_RPC", "config-file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Watch(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := w.Reload(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var c struct {
+		Port int `config:"port"`
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		w.Read(func(v *viper.Viper) {
+			if got := v.GetInt("port"); got != 1000 {
+				t.Errorf("port = %d, want 1000", got)
+			}
+		})
+
+		if err := Decode(v, &c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Port != 1000 {
+			t.Errorf("decoded port = %d, want 1000", c.Port)
+		}
+	}
+}
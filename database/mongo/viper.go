@@ -2,52 +2,59 @@ package mongo
 
 import "github.com/digital-dream-labs/hugh/config"
 
+type viperConfig struct {
+	Username     string `config:"username" desc:"Database user"`
+	Password     string `config:"password" secret:"true" desc:"Database password"`
+	Name         string `config:"name" desc:"Database name"`
+	AuthName     string `config:"auth-name" desc:"Database to authenticate against"`
+	Host         string `config:"host" desc:"Database host"`
+	Port         int    `config:"port" min:"0" max:"65535" desc:"Database port, not allowed with cluster"`
+	Direct       bool   `config:"direct" desc:"Connect directly to a single host"`
+	Cluster      bool   `config:"cluster" desc:"Connect to a clustered (srv) host"`
+	RetryWrites  bool   `config:"retry-writes" desc:"Retry failed writes"`
+	WriteConcern string `config:"write-concern" desc:"Write concern: 0, 1 or majority"`
+}
+
+func init() {
+	config.Declare("database/mongo", "DDL_DB", viperConfig{})
+}
+
 // viperize augments options based on viper config
 func (o *opts) viperize(args ...string) error {
+	c := viperConfig{
+		Username:     o.username,
+		Password:     o.password,
+		Name:         o.name,
+		AuthName:     o.authdbname,
+		Host:         o.host,
+		Port:         o.port,
+		Direct:       o.direct,
+		Cluster:      o.cluster,
+		RetryWrites:  o.retryWrites,
+		WriteConcern: o.writeConcern,
+	}
+
 	v, err := config.New("DDL_DB", args...)
 	if err != nil {
 		return err
 	}
 
-	if x := "username"; v.IsSet(x) {
-		o.username = v.GetString(x)
-	}
-
-	if x := "password"; v.IsSet(x) {
-		o.password = v.GetString(x)
-	}
-
-	if x := "name"; v.IsSet(x) {
-		o.name = v.GetString(x)
-	}
-
-	if x := "auth-name"; v.IsSet(x) {
-		o.authdbname = v.GetString(x)
-	}
-
-	if x := "host"; v.IsSet(x) {
-		o.host = v.GetString(x)
-	}
-
-	if x := "port"; v.IsSet(x) {
-		o.port = v.GetInt(x)
-	}
-
-	if x := "direct"; v.IsSet(x) {
-		o.direct = v.GetBool(x)
+	if err := config.Decode(v, &c); err != nil {
+		return err
 	}
 
-	if x := "cluster"; v.IsSet(x) {
-		o.cluster = v.GetBool(x)
-	}
+	config.Register("database/mongo", v)
 
-	if x := "retry-writes"; v.IsSet(x) {
-		o.retryWrites = v.GetBool(x)
-	}
-
-	if x := "write-concern"; v.IsSet(x) {
-		o.writeConcern = v.GetString(x)
-	}
+	o.username = c.Username
+	o.password = c.Password
+	o.name = c.Name
+	o.authdbname = c.AuthName
+	o.host = c.Host
+	o.port = c.Port
+	o.direct = c.Direct
+	o.cluster = c.Cluster
+	o.retryWrites = c.RetryWrites
+	o.writeConcern = c.WriteConcern
 
 	return nil
 }
@@ -2,40 +2,50 @@ package sql
 
 import "github.com/digital-dream-labs/hugh/config"
 
+type viperConfig struct {
+	Type     string `config:"type" enum:"mysql,postgres" desc:"Database driver"`
+	Username string `config:"username" desc:"Database user"`
+	Password string `config:"password" secret:"true" desc:"Database password"`
+	Name     string `config:"name" desc:"Database name"`
+	Host     string `config:"host" desc:"Database host"`
+	Port     int    `config:"port" min:"0" max:"65535" desc:"Database port"`
+	TLSMode  string `config:"tls-mode" desc:"Postgres sslmode"`
+}
+
+func init() {
+	config.Declare("database/sql", "DDL_DB", viperConfig{})
+}
+
 // viperize augments options based on viper config
 func (o *options) viperize(args ...string) error {
+	c := viperConfig{
+		Type:     o.databaseType,
+		Username: o.username,
+		Password: o.password,
+		Name:     o.name,
+		Host:     o.host,
+		Port:     o.port,
+		TLSMode:  o.tlsMode,
+	}
+
 	v, err := config.New("DDL_DB", args...)
 	if err != nil {
 		return err
 	}
 
-	if x := "type"; v.IsSet(x) {
-		o.databaseType = v.GetString(x)
-	}
-
-	if x := "username"; v.IsSet(x) {
-		o.username = v.GetString(x)
-	}
-
-	if x := "password"; v.IsSet(x) {
-		o.password = v.GetString(x)
-	}
-
-	if x := "name"; v.IsSet(x) {
-		o.name = v.GetString(x)
-	}
-
-	if x := "host"; v.IsSet(x) {
-		o.host = v.GetString(x)
+	if err := config.Decode(v, &c); err != nil {
+		return err
 	}
 
-	if x := "port"; v.IsSet(x) {
-		o.port = v.GetInt(x)
-	}
+	config.Register("database/sql", v)
 
-	if x := "tls-mode"; v.IsSet(x) {
-		o.tlsMode = v.GetString(x)
-	}
+	o.databaseType = c.Type
+	o.username = c.Username
+	o.password = c.Password
+	o.name = c.Name
+	o.host = c.Host
+	o.port = c.Port
+	o.tlsMode = c.TLSMode
 
 	return nil
 }
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 
 	"github.com/pkg/errors"
@@ -10,6 +11,7 @@ import (
 
 	log "github.com/digital-dream-labs/hugh/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -113,6 +115,22 @@ func (c *Client) Conn() *grpc.ClientConn {
 	return c.conn
 }
 
+// Check reports whether the connection is usable.  It can be registered as a server health check.
+func (c *Client) Check(ctx context.Context) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn == nil {
+		return errors.New("client not connected")
+	}
+
+	switch st := c.conn.GetState(); st {
+	case connectivity.Ready, connectivity.Idle:
+		return nil
+	default:
+		return fmt.Errorf("connection to %s is %s", c.target, st)
+	}
+}
+
 func clientTLS(o *options) *tls.Config {
 	//nolint -- once older services are upgraded, fix this!
 	return &tls.Config{
@@ -21,40 +21,57 @@ const (
 
 // The remaining k,v's are treated as viper BindEnv args where k is the viper key and v is the env variable. Note that prefix is not used when specifically binding vars.
 
+type viperConfig struct {
+	Target         string `config:"target" desc:"Address of the server"`
+	Insecure       bool   `config:"insecure" desc:"Skip verification of the server certificate"`
+	DisableTLS     bool   `config:"disable-tls" desc:"Connect without TLS"`
+	TLSCertificate string `config:"tls-certificate" desc:"Client certificate"`
+	TLSKey         string `config:"tls-key" secret:"true" desc:"Private key that pairs with the client certificate"`
+	TLSCA          string `config:"tls-ca" desc:"Certificate authority used instead of the system pool"`
+}
+
+func init() {
+	config.Declare("grpc/client", "DDL_RPC", viperConfig{})
+}
+
 func (o *options) viperize(args ...string) error {
+	c := viperConfig{
+		Target:     o.target,
+		Insecure:   o.insecure,
+		DisableTLS: o.disableTLS,
+	}
+
 	v, err := config.New("DDL_RPC", args...)
 	if err != nil {
 		return err
 	}
 
-	if x := "target"; v.IsSet(x) {
-		o.target = v.GetString(x)
+	if err := config.Decode(v, &c); err != nil {
+		return err
 	}
 
-	if x := "insecure"; v.IsSet(x) {
-		o.insecure = v.GetBool(x)
-	}
+	config.Register("grpc/client", v)
 
-	if v.IsSet("disable-tls") {
-		o.disableTLS = true
-	}
+	o.target = c.Target
+	o.insecure = c.Insecure
+	o.disableTLS = c.DisableTLS
 
-	if v.IsSet("tls-certificate") && v.IsSet("tls-key") {
+	if c.TLSCertificate != "" && c.TLSKey != "" {
 		crt, err := tls.X509KeyPair(
-			[]byte(v.GetString("tls-certificate")),
-			[]byte(v.GetString("tls-key")),
+			[]byte(c.TLSCertificate),
+			[]byte(c.TLSKey),
 		)
 		if err != nil {
 			return err
 		}
 		o.certificates = append(o.certificates, crt)
-		o.log.Debug("RPC::tls-certificate: ", v.GetString("tls-certificate"))
-		o.log.Debug("RPC::tls-key: ", v.GetString("tls-key"))
+		o.log.Debug("RPC::tls-certificate: ", c.TLSCertificate)
+		o.log.Debug("RPC::tls-key: ", config.Redact(c.TLSKey))
 	}
 
-	if v.IsSet("tls-ca") {
+	if c.TLSCA != "" {
 		pool := x509.NewCertPool()
-		if ok := pool.AppendCertsFromPEM([]byte(v.GetString("tls-ca"))); !ok {
+		if ok := pool.AppendCertsFromPEM([]byte(c.TLSCA)); !ok {
 			return fmt.Errorf("CA is not a valid pem file")
 		}
 
@@ -0,0 +1,221 @@
+// Package auth authenticates gRPC calls with JWT bearer tokens passed in the authorization metadata.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/log"
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Authenticator verifies bearer tokens and enforces the rule of each method.
+type Authenticator struct {
+	keys     KeySource
+	issuer   string
+	audience []string
+	leeway   time.Duration
+	rules    map[string]Rule
+	def      Rule
+	log      log.Logger
+}
+
+// New constructs an Authenticator.  A key source is required.
+func New(opts ...Option) (*Authenticator, error) {
+	cfg := options{
+		log:     log.Base(),
+		leeway:  jwt.DefaultLeeway,
+		refresh: defaultRefresh,
+		rules:   make(map[string]Rule),
+	}
+
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	if cfg.errored() {
+		return nil, fmt.Errorf("error during auth setup: %v", cfg.errs)
+	}
+
+	keys := cfg.keys
+	switch {
+	case keys != nil:
+	case cfg.jwksURL != "":
+		keys = JWKSURL(cfg.jwksURL, cfg.refresh)
+	case cfg.jwksFile != "":
+		keys = JWKSFile(cfg.jwksFile, cfg.refresh)
+	default:
+		return nil, errors.New("a JWKS file, JWKS URL or key source is required")
+	}
+
+	return &Authenticator{
+		keys:     keys,
+		issuer:   cfg.issuer,
+		audience: cfg.audience,
+		leeway:   cfg.leeway,
+		rules:    cfg.rules,
+		def:      cfg.def,
+		log:      cfg.log,
+	}, nil
+}
+
+// UnaryServerInterceptor returns an interceptor that authenticates unary calls.
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.Authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that authenticates streams.
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.Authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+// Authenticate checks the token of an incoming call to method against the method's rule, and returns a
+// context carrying its claims.  Public methods accept calls without a token, or with an invalid one.
+func (a *Authenticator) Authenticate(ctx context.Context, method string) (context.Context, error) {
+	rule := a.ruleFor(method)
+
+	raw, err := bearer(ctx)
+	if err != nil {
+		if rule.Public {
+			return ctx, nil
+		}
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	claims, err := a.Verify(ctx, raw)
+	if err != nil {
+		if rule.Public {
+			return ctx, nil
+		}
+		a.log.Debugf("rejected token for %s: %v", method, err)
+		return ctx, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	for _, s := range rule.Scopes {
+		if !claims.HasScope(s) {
+			return ctx, status.Errorf(codes.PermissionDenied, "scope %q is required", s)
+		}
+	}
+
+	return NewContext(ctx, claims), nil
+}
+
+// Verify checks the signature, issuer, audience and expiry of a raw token, and returns its claims.
+func (a *Authenticator) Verify(ctx context.Context, raw string) (*Claims, error) {
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	h := tok.Headers[0]
+
+	key, err := a.keys.Key(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if jwk, ok := key.(jose.JSONWebKey); ok && jwk.Algorithm != "" && jwk.Algorithm != h.Algorithm {
+		return nil, fmt.Errorf("key %q is for %s, not %s", h.KeyID, jwk.Algorithm, h.Algorithm)
+	}
+
+	var (
+		std   jwt.Claims
+		extra map[string]interface{}
+	)
+	if err := tok.Claims(key, &std, &extra); err != nil {
+		return nil, err
+	}
+
+	if std.Expiry == nil {
+		return nil, errors.New("token has no expiry")
+	}
+
+	expected := jwt.Expected{
+		Issuer: a.issuer,
+		Time:   time.Now(),
+	}
+	if err := std.ValidateWithLeeway(expected, a.leeway); err != nil {
+		return nil, err
+	}
+
+	if len(a.audience) > 0 && !anyAudience(std.Audience, a.audience) {
+		return nil, jwt.ErrInvalidAudience
+	}
+
+	return newClaims(std, extra), nil
+}
+
+// ruleFor returns the rule of an exact match, then of the longest matching prefix ending in *, then
+// the default rule.
+func (a *Authenticator) ruleFor(method string) Rule {
+	if r, ok := a.rules[method]; ok {
+		return r
+	}
+
+	best, found := "", false
+	for pattern := range a.rules {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix == pattern || !strings.HasPrefix(method, prefix) {
+			continue
+		}
+		if !found || len(prefix) > len(best) {
+			best, found = prefix, true
+		}
+	}
+
+	if found {
+		return a.rules[best+"*"]
+	}
+	return a.def
+}
+
+// bearer returns the token of the authorization metadata.
+func bearer(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	vals := md.Get("authorization")
+	if len(vals) == 0 {
+		return "", errors.New("authorization is required")
+	}
+
+	parts := strings.SplitN(vals[0], " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || parts[1] == "" {
+		return "", errors.New("authorization must be a bearer token")
+	}
+
+	return strings.TrimSpace(parts[1]), nil
+}
+
+func anyAudience(have jwt.Audience, want []string) bool {
+	for _, w := range want {
+		if have.Contains(w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/auth"
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/auth/authtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	iss := authtest.NewIssuer("https://issuer.example.com")
+	other := authtest.NewIssuer("https://issuer.example.com")
+
+	a, err := auth.New(
+		auth.WithKeySource(iss.KeySource()),
+		auth.WithIssuer("https://issuer.example.com"),
+		auth.WithAudience("echo"),
+		auth.WithPublic("/grpc.health.v1.Health/*"),
+		auth.WithScopes("/echo.Echo/Write", "echo:write"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   codes.Code
+	}{
+		{
+			name:   "public without token",
+			method: "/grpc.health.v1.Health/Check",
+		},
+		{
+			name:   "missing token",
+			method: "/echo.Echo/Read",
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "valid token",
+			method: "/echo.Echo/Read",
+			token:  iss.Sign(map[string]interface{}{"sub": "bob", "aud": "echo", "exp": now.Add(time.Hour).Unix()}),
+		},
+		{
+			name:   "expired",
+			method: "/echo.Echo/Read",
+			token:  iss.Sign(map[string]interface{}{"sub": "bob", "aud": "echo", "exp": now.Add(-time.Hour).Unix()}),
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "no expiry",
+			method: "/echo.Echo/Read",
+			token:  iss.Sign(map[string]interface{}{"sub": "bob", "aud": "echo"}),
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "wrong issuer",
+			method: "/echo.Echo/Read",
+			token:  iss.Sign(map[string]interface{}{"iss": "mallory", "aud": "echo", "exp": now.Add(time.Hour).Unix()}),
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "wrong audience",
+			method: "/echo.Echo/Read",
+			token:  iss.Sign(map[string]interface{}{"aud": "other", "exp": now.Add(time.Hour).Unix()}),
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "wrong key",
+			method: "/echo.Echo/Read",
+			token:  other.Sign(map[string]interface{}{"aud": "echo", "exp": now.Add(time.Hour).Unix()}),
+			want:   codes.Unauthenticated,
+		},
+		{
+			name:   "missing scope",
+			method: "/echo.Echo/Write",
+			token:  iss.Sign(map[string]interface{}{"aud": "echo", "exp": now.Add(time.Hour).Unix(), "scope": "echo:read"}),
+			want:   codes.PermissionDenied,
+		},
+		{
+			name:   "scope granted",
+			method: "/echo.Echo/Write",
+			token:  iss.Sign(map[string]interface{}{"aud": "echo", "exp": now.Add(time.Hour).Unix(), "scope": "echo:read echo:write"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+			}
+
+			handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+				if _, ok := auth.FromContext(ctx); !ok && tt.token != "" {
+					t.Error("claims missing from context")
+				}
+				return nil, nil
+			}
+
+			_, err := a.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %s, want %s (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package authtest issues tokens locally, standing in for an identity provider in tests of services
+// using auth.
+package authtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/auth"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const keyID = "authtest"
+
+// Issuer signs tokens with a key generated when it is created.
+type Issuer struct {
+	// Name is the issuer of every token.
+	Name   string
+	key    *ecdsa.PrivateKey
+	signer jose.Signer
+}
+
+// NewIssuer creates an issuer named name.
+func NewIssuer(name string) *Issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), keyID),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Issuer{
+		Name:   name,
+		key:    key,
+		signer: signer,
+	}
+}
+
+// JWKS returns the JSON Web Key Set tokens are verified with, for instance to serve or write to a file.
+func (i *Issuer) JWKS() []byte {
+	set := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{
+			Key:       i.key.Public(),
+			KeyID:     keyID,
+			Algorithm: string(jose.ES256),
+			Use:       "sig",
+		}},
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// KeySource returns the keys tokens are verified with.
+func (i *Issuer) KeySource() auth.KeySource {
+	ks, err := auth.StaticJWKS(i.JWKS())
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}
+
+// Token returns a token for subject, valid for an hour, granting scopes.
+func (i *Issuer) Token(subject string, scopes ...string) string {
+	now := time.Now()
+
+	claims := map[string]interface{}{
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	return i.Sign(claims)
+}
+
+// Sign returns a token carrying claims, with iss set to the issuer's name unless claims sets it.
+func (i *Issuer) Sign(claims map[string]interface{}) string {
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = i.Name
+	}
+
+	tok, err := jwt.Signed(i.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+	return tok
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Claims are the verified claims of a token.
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Time
+	// Scopes are read from the space separated "scope" claim, or the "scp" claim.
+	Scopes []string
+	// Raw holds every claim of the token, for claims not covered above.
+	Raw map[string]interface{}
+}
+
+func newClaims(std jwt.Claims, raw map[string]interface{}) *Claims {
+	c := Claims{
+		Issuer:   std.Issuer,
+		Subject:  std.Subject,
+		Audience: std.Audience,
+		Expiry:   std.Expiry.Time(),
+		Raw:      raw,
+	}
+
+	if s, ok := raw["scope"].(string); ok {
+		c.Scopes = strings.Fields(s)
+	}
+
+	switch scp := raw["scp"].(type) {
+	case string:
+		c.Scopes = append(c.Scopes, strings.Fields(scp)...)
+	case []interface{}:
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				c.Scopes = append(c.Scopes, s)
+			}
+		}
+	}
+
+	return &c
+}
+
+// HasScope reports whether scope was granted.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying c.
+func NewContext(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the claims of the caller, if it was authenticated.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(contextKey{}).(*Claims)
+	return c, ok
+}
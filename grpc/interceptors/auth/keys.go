@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	defaultRefresh = time.Hour
+	// minRefresh throttles reloads caused by tokens naming unknown keys.  It is also the first wait after
+	// a failed load, doubling with every failure up to maxBackoff.
+	minRefresh   = 10 * time.Second
+	maxBackoff   = 5 * time.Minute
+	fetchTimeout = 10 * time.Second
+)
+
+// KeySource provides the public keys tokens are verified with.
+type KeySource interface {
+	// Key returns the key with the given key ID.  kid is empty if the token doesn't name one.
+	Key(ctx context.Context, kid string) (interface{}, error)
+}
+
+// jwks is a JSON Web Key Set, reloaded every refresh and whenever a token names an unknown key.
+// Keys are looked up under mu, but the set is loaded outside it.  Once a set has loaded, it is served
+// while the next one loads in the background, so a slow or unreachable identity provider only holds up
+// callers waiting for a key it doesn't have.  After a failed load, the next one waits for backoff,
+// which doubles with every failure.
+type jwks struct {
+	load    func(ctx context.Context) ([]byte, error)
+	refresh time.Duration
+	mu      sync.Mutex
+	set     *jose.JSONWebKeySet
+	loaded  time.Time
+	loading *loading
+	err     error
+	backoff time.Duration
+	retry   time.Time
+}
+
+// loading is a load in progress, shared by every caller that needs the new set.
+type loading struct {
+	done chan struct{}
+	err  error
+}
+
+// JWKSFile returns a KeySource reading a JSON Web Key Set from a file.
+func JWKSFile(path string, refresh time.Duration) KeySource {
+	return &jwks{
+		refresh: refresh,
+		load: func(context.Context) ([]byte, error) {
+			return ioutil.ReadFile(path)
+		},
+	}
+}
+
+// JWKSURL returns a KeySource fetching a JSON Web Key Set from url, such as an identity provider's
+// jwks_uri.
+func JWKSURL(url string, refresh time.Duration) KeySource {
+	client := http.Client{Timeout: fetchTimeout}
+
+	return &jwks{
+		refresh: refresh,
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+			}
+			return ioutil.ReadAll(resp.Body)
+		},
+	}
+}
+
+// StaticJWKS returns a KeySource serving a fixed JSON Web Key Set.
+func StaticJWKS(data []byte) (KeySource, error) {
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	return &jwks{
+		set:    &set,
+		loaded: time.Now(),
+	}, nil
+}
+
+func (j *jwks) Key(ctx context.Context, kid string) (interface{}, error) {
+	set, loaded := j.current()
+
+	switch {
+	case set == nil:
+		if err := j.reload(ctx, loaded); err != nil {
+			return nil, err
+		}
+		set, loaded = j.current()
+	case j.refresh > 0 && time.Since(loaded) > j.refresh:
+		_, _ = j.start(loaded)
+	}
+
+	if k, ok := find(set, kid); ok {
+		return k, nil
+	}
+
+	if time.Since(loaded) > minRefresh {
+		if err := j.reload(ctx, loaded); err != nil {
+			return nil, err
+		}
+		set, _ = j.current()
+		if k, ok := find(set, kid); ok {
+			return k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (j *jwks) current() (*jose.JSONWebKeySet, time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.set, j.loaded
+}
+
+// reload loads the set, or waits for the load already in progress, unless it was loaded after since.
+// The current set is kept if the new one can't be loaded.  The load isn't tied to ctx, since other
+// callers may be waiting for it.
+func (j *jwks) reload(ctx context.Context, since time.Time) error {
+	l, err := j.start(since)
+	if l == nil {
+		return err
+	}
+
+	select {
+	case <-l.done:
+		return l.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start returns the load in progress, starting one unless the set was loaded after since.  While
+// backing off after a failed load, it returns that load's error instead.
+func (j *jwks) start(since time.Time) (*loading, error) {
+	if j.load == nil {
+		return nil, nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case j.loaded.After(since):
+		return nil, nil
+	case j.loading != nil:
+		return j.loading, nil
+	case time.Now().Before(j.retry):
+		return nil, j.err
+	}
+
+	j.loading = &loading{done: make(chan struct{})}
+	go j.fetch(j.loading)
+	return j.loading, nil
+}
+
+func (j *jwks) fetch(l *loading) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	var set jose.JSONWebKeySet
+	data, err := j.load(ctx)
+	if err == nil {
+		err = json.Unmarshal(data, &set)
+	}
+
+	j.mu.Lock()
+	if err == nil {
+		j.set = &set
+		j.loaded = time.Now()
+		j.backoff = 0
+	} else {
+		j.backoff *= 2
+		if j.backoff < minRefresh {
+			j.backoff = minRefresh
+		}
+		if j.backoff > maxBackoff {
+			j.backoff = maxBackoff
+		}
+		j.retry = time.Now().Add(j.backoff)
+	}
+	j.err = err
+	j.loading = nil
+	j.mu.Unlock()
+
+	l.err = err
+	close(l.done)
+}
+
+// find returns the key of set named kid, or the only signing key if kid is empty.
+func find(set *jose.JSONWebKeySet, kid string) (jose.JSONWebKey, bool) {
+	if set == nil {
+		return jose.JSONWebKey{}, false
+	}
+
+	if kid != "" {
+		if keys := set.Key(kid); len(keys) > 0 {
+			return keys[0], true
+		}
+		return jose.JSONWebKey{}, false
+	}
+
+	var found []jose.JSONWebKey
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			found = append(found, k)
+		}
+	}
+	if len(found) == 1 {
+		return found[0], true
+	}
+	return jose.JSONWebKey{}, false
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+func keySet(t *testing.T, kids ...string) []byte {
+	t.Helper()
+
+	var set jose.JSONWebKeySet
+	for _, kid := range kids {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: &priv.PublicKey, KeyID: kid, Algorithm: "ES256", Use: "sig"})
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestJWKSReload(t *testing.T) {
+	var initial jose.JSONWebKeySet
+	if err := json.Unmarshal(keySet(t, "a"), &initial); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := keySet(t, "a", "b")
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var loads int32
+
+	j := &jwks{
+		set:    &initial,
+		loaded: time.Now().Add(-time.Minute),
+		load: func(context.Context) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			started <- struct{}{}
+			<-release
+			return rotated, nil
+		},
+	}
+
+	// Callers asking for the new key wait for a single load.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := j.Key(context.Background(), "b"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	<-started
+
+	// Known keys are served while the load is in progress.
+	found := make(chan error, 1)
+	go func() {
+		_, err := j.Key(context.Background(), "a")
+		found <- err
+	}()
+
+	select {
+	case err := <-found:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Key blocked on the load in progress")
+	}
+
+	// A caller can give up waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := j.Key(ctx, "b"); err != context.Canceled {
+		t.Errorf("Key with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("loads = %d, want 1", n)
+	}
+}
+
+// TestJWKSUnreachable serves the loaded set without waiting once the identity provider stops answering.
+func TestJWKSUnreachable(t *testing.T) {
+	initial := keySet(t, "a")
+	unreachable := make(chan struct{})
+	defer close(unreachable)
+	started := make(chan struct{}, 1)
+	var loads int32
+
+	j := &jwks{
+		refresh: time.Millisecond,
+		load: func(ctx context.Context) ([]byte, error) {
+			if atomic.AddInt32(&loads, 1) == 1 {
+				return initial, nil
+			}
+			started <- struct{}{}
+			select {
+			case <-unreachable:
+			case <-ctx.Done():
+			}
+			return nil, errors.New("unreachable")
+		},
+	}
+
+	if _, err := j.Key(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if _, err := j.Key(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("100 Key calls took %s with the identity provider unreachable", d)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the expired set wasn't reloaded")
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Errorf("loads = %d, want 2", n)
+	}
+}
+
+// TestJWKSBackoff doesn't retry a failed load until the backoff has passed.
+func TestJWKSBackoff(t *testing.T) {
+	var loads int32
+
+	j := &jwks{
+		load: func(context.Context) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, errors.New("unreachable")
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := j.Key(context.Background(), "a"); err == nil {
+			t.Fatal("Key without a key set = nil error")
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("loads = %d, want 1", n)
+	}
+
+	j.mu.Lock()
+	j.retry = time.Now()
+	j.mu.Unlock()
+
+	if _, err := j.Key(context.Background(), "a"); err == nil {
+		t.Fatal("Key without a key set = nil error")
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Errorf("loads = %d after the backoff, want 2", n)
+	}
+	if j.backoff != 2*minRefresh {
+		t.Errorf("backoff = %s after two failures, want %s", j.backoff, 2*minRefresh)
+	}
+}
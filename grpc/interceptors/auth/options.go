@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/digital-dream-labs/hugh/log"
+)
+
+// Rule is the policy of a method.
+type Rule struct {
+	// Public methods may be called without a token.
+	Public bool
+	// Scopes must all have been granted to the caller.
+	Scopes []string
+}
+
+// Option provides a function definition to set options
+type Option func(*options)
+
+type options struct {
+	log      log.Logger
+	errs     []error
+	keys     KeySource
+	jwksFile string
+	jwksURL  string
+	refresh  time.Duration
+	issuer   string
+	audience []string
+	leeway   time.Duration
+	rules    map[string]Rule
+	def      Rule
+}
+
+func (o *options) errored() bool {
+	return len(o.errs) > 0
+}
+
+// WithViper specifies that the authenticator should construct its options using viper.
+func WithViper(args ...string) Option {
+	return func(o *options) {
+		if err := o.viperize(args...); err != nil {
+			o.errs = append(o.errs, err)
+		}
+	}
+}
+
+// WithLogger set the log instance.
+func WithLogger(l log.Logger) Option {
+	return func(o *options) {
+		o.log = l
+	}
+}
+
+// WithJWKSFile verifies tokens with the JSON Web Key Set in a file.
+func WithJWKSFile(path string) Option {
+	return func(o *options) {
+		o.jwksFile = path
+	}
+}
+
+// WithJWKSURL verifies tokens with the JSON Web Key Set served at url.
+func WithJWKSURL(url string) Option {
+	return func(o *options) {
+		o.jwksURL = url
+	}
+}
+
+// WithJWKSRefresh sets how often the key set is reloaded.  Default: 1h
+func WithJWKSRefresh(d time.Duration) Option {
+	return func(o *options) {
+		o.refresh = d
+	}
+}
+
+// WithKeySource verifies tokens with the keys of k, overriding any JWKS file or URL.
+func WithKeySource(k KeySource) Option {
+	return func(o *options) {
+		o.keys = k
+	}
+}
+
+// WithIssuer requires tokens to be issued by iss.
+func WithIssuer(iss string) Option {
+	return func(o *options) {
+		o.issuer = iss
+	}
+}
+
+// WithAudience requires tokens to be intended for one of aud.
+func WithAudience(aud ...string) Option {
+	return func(o *options) {
+		o.audience = aud
+	}
+}
+
+// WithLeeway sets the clock skew allowed when checking expiry.  Default: 1m
+func WithLeeway(d time.Duration) Option {
+	return func(o *options) {
+		o.leeway = d
+	}
+}
+
+// WithRule sets the rule of a method, such as "/pkg.Service/Method".  A method ending in * sets the
+// rule of every method starting with it, such as "/grpc.health.v1.Health/*".
+func WithRule(method string, r Rule) Option {
+	return func(o *options) {
+		o.rules[method] = r
+	}
+}
+
+// WithPublic lets methods be called without a token.
+func WithPublic(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.rules[m] = Rule{Public: true}
+		}
+	}
+}
+
+// WithScopes requires the caller of method to have been granted scopes.
+func WithScopes(method string, scopes ...string) Option {
+	return func(o *options) {
+		o.rules[method] = Rule{Scopes: scopes}
+	}
+}
+
+// WithDefaultRule sets the rule of methods without one.  By default any valid token is accepted.
+func WithDefaultRule(r Rule) Option {
+	return func(o *options) {
+		o.def = r
+	}
+}
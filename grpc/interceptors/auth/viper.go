@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/config"
+)
+
+// viperize augments options based on viper config
+//
+// args are a k, v scheme. Special keys are:
+
+const (
+	// EnvironmentPrefix sets the prefix to strip from environment variables when resolving keys. Default: "DDL_AUTH".
+	EnvironmentPrefix = "env-prefix"
+	// EnvironmentReplace takes a comma separated list of old,new. Default: .,_,-,_
+	EnvironmentReplace = "env-replace"
+)
+
+// The remaining k,v's are treated as viper BindEnv args where k is the viper key and v is the env variable. Note that prefix is not used when specifically binding vars.
+
+type viperConfig struct {
+	JWKSFile      string        `config:"jwks-file" desc:"JSON Web Key Set file tokens are verified with"`
+	JWKSURL       string        `config:"jwks-url" desc:"JSON Web Key Set URL tokens are verified with"`
+	JWKSRefresh   time.Duration `config:"jwks-refresh" desc:"How often the key set is reloaded"`
+	Issuer        string        `config:"issuer" desc:"Required token issuer"`
+	Audience      []string      `config:"audience" desc:"Accepted token audiences"`
+	Leeway        time.Duration `config:"leeway" desc:"Clock skew allowed when checking expiry"`
+	PublicMethods []string      `config:"public-methods" desc:"Methods callable without a token, may end in *"`
+	MethodScopes  []string      `config:"method-scopes" desc:"Scopes required by methods, as method=scope scope"`
+}
+
+func init() {
+	config.Declare("grpc/interceptors/auth", "DDL_AUTH", viperConfig{})
+}
+
+func (o *options) viperize(args ...string) error {
+	c := viperConfig{
+		JWKSFile:    o.jwksFile,
+		JWKSURL:     o.jwksURL,
+		JWKSRefresh: o.refresh,
+		Issuer:      o.issuer,
+		Audience:    o.audience,
+		Leeway:      o.leeway,
+	}
+
+	v, err := config.New("DDL_AUTH", args...)
+	if err != nil {
+		return err
+	}
+
+	if err := config.Decode(v, &c); err != nil {
+		return err
+	}
+
+	config.Register("grpc/interceptors/auth", v)
+
+	o.jwksFile = c.JWKSFile
+	o.jwksURL = c.JWKSURL
+	o.refresh = c.JWKSRefresh
+	o.issuer = c.Issuer
+	o.audience = c.Audience
+	o.leeway = c.Leeway
+
+	for _, m := range c.PublicMethods {
+		o.rules[m] = Rule{Public: true}
+	}
+
+	for _, ms := range c.MethodScopes {
+		parts := strings.SplitN(ms, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("method-scopes entry %q is not method=scope scope", ms)
+		}
+		o.rules[parts[0]] = Rule{Scopes: strings.Fields(parts[1])}
+	}
+
+	o.log.Debugf("AUTH::issuer: %s", o.issuer)
+	o.log.Debugf("AUTH::audience: %v", o.audience)
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package requestid propagates a request ID through gRPC metadata, the HTTP gateway and the log context,
+// so that a request can be followed across services.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Header is the metadata key, and HTTP header, carrying the request ID.
+const Header = "x-request-id"
+
+// LogField is the log field the request ID is recorded under.
+const LogField = "request_id"
+
+// MaxLength is the longest request ID accepted from a caller.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID of ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// New generates a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// incoming returns the request ID sent by the caller, or a new one if it sent none or one that isn't
+// valid.
+func incoming(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(Header); len(ids) > 0 && valid(ids[0]) {
+		return ids[0]
+	}
+	return New()
+}
+
+// valid reports whether id is safe to log and forward: no longer than MaxLength and made of HTTP token
+// characters only.
+func valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// outgoing adds the request ID of ctx, if any, to the outgoing metadata.
+func outgoing(ctx context.Context) context.Context {
+	id := FromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(Header)) > 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, Header, id)
+}
@@ -0,0 +1,140 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming metadata.MD
+		want     string
+	}{
+		{
+			name:     "forwarded",
+			incoming: metadata.Pairs(Header, "abc"),
+			want:     "abc",
+		},
+		{
+			name:     "generated",
+			incoming: metadata.MD{},
+		},
+		{
+			name:     "too long",
+			incoming: metadata.Pairs(Header, strings.Repeat("a", MaxLength+1)),
+		},
+		{
+			name:     "not a token",
+			incoming: metadata.Pairs(Header, "abc\ninjected=true"),
+		},
+		{
+			name:     "longest",
+			incoming: metadata.Pairs(Header, strings.Repeat("a", MaxLength)),
+			want:     strings.Repeat("a", MaxLength),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.incoming)
+
+			var got string
+			handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+				got = FromContext(ctx)
+
+				// The ID is passed on to downstream calls.
+				md, _ := metadata.FromOutgoingContext(outgoing(ctx))
+				if ids := md.Get(Header); len(ids) != 1 || ids[0] != got {
+					t.Errorf("outgoing %s = %v, want [%s]", Header, ids, got)
+				}
+				return nil, nil
+			}
+
+			if _, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.want != "" && got != tt.want:
+				t.Errorf("request ID = %q, want %q", got, tt.want)
+			case tt.want == "" && len(got) != 32:
+				t.Errorf("generated request ID = %q, want 32 hex characters", got)
+			}
+		})
+	}
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
+
+func (s *testServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *testServerStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming metadata.MD
+		want     string
+	}{
+		{
+			name:     "forwarded",
+			incoming: metadata.Pairs(Header, "abc"),
+			want:     "abc",
+		},
+		{
+			name:     "generated",
+			incoming: metadata.MD{},
+		},
+		{
+			name:     "not a token",
+			incoming: metadata.Pairs(Header, "a b"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := &testServerStream{ctx: metadata.NewIncomingContext(context.Background(), tt.incoming)}
+
+			var got string
+			handler := func(_ interface{}, ss grpc.ServerStream) error {
+				got = FromContext(ss.Context())
+				return nil
+			}
+
+			if err := StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{}, handler); err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.want != "" && got != tt.want:
+				t.Errorf("request ID = %q, want %q", got, tt.want)
+			case tt.want == "" && len(got) != 32:
+				t.Errorf("generated request ID = %q, want 32 hex characters", got)
+			}
+
+			// The ID is returned to the caller in the header and the trailer.
+			for name, md := range map[string]metadata.MD{"header": ss.header, "trailer": ss.trailer} {
+				if ids := md.Get(Header); len(ids) != 1 || ids[0] != got {
+					t.Errorf("%s %s = %v, want [%s]", name, Header, ids, got)
+				}
+			}
+		})
+	}
+}
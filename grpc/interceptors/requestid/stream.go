@@ -0,0 +1,41 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/digital-dream-labs/hugh/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type requestIDServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := incoming(ss.Context())
+
+		ctx := NewContext(log.AddToContext(ss.Context()), id)
+		log.AddContextFields(ctx, log.Fields{LogField: id})
+
+		md := metadata.Pairs(Header, id)
+		_ = ss.SetHeader(md)
+		ss.SetTrailer(md)
+
+		return handler(srv, &requestIDServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// StreamClientInterceptor is the streaming counterpart of UnaryClientInterceptor.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
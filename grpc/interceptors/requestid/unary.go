@@ -0,0 +1,35 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/digital-dream-labs/hugh/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor returns an interceptor that reads the request ID from the incoming metadata,
+// or generates one.  It adds the ID to the context and the log context, and returns it in the response
+// header and trailer.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := incoming(ctx)
+
+		ctx = NewContext(log.AddToContext(ctx), id)
+		log.AddContextFields(ctx, log.Fields{LogField: id})
+
+		md := metadata.Pairs(Header, id)
+		_ = grpc.SetHeader(ctx, md)
+		_ = grpc.SetTrailer(ctx, md)
+
+		return handler(ctx, req)
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor that passes the request ID of the context on to the
+// called service.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
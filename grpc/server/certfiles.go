@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// certSettle is how long the certificate files must stay unchanged before they are reloaded, so that a
+// rotation replacing several files is picked up at once.
+const certSettle = 100 * time.Millisecond
+
+// certFiles reloads the serving certificate and the client CA pool from disk whenever the files change,
+// and optionally on an interval.
+type certFiles struct {
+	cert     string
+	key      string
+	ca       string
+	interval time.Duration
+	kp       *keypair
+	log      log.Logger
+	fs       *fsnotify.Watcher
+	done     chan struct{}
+	once     sync.Once
+}
+
+// load reads the files into the keypair.
+func (c *certFiles) load() error {
+	crt, err := tls.LoadX509KeyPair(c.cert, c.key)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if c.ca != "" {
+		pem, err := ioutil.ReadFile(c.ca)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(pem); !ok {
+			return fmt.Errorf("%s is not a valid pem file", c.ca)
+		}
+	}
+
+	leaf, err := x509.ParseCertificate(crt.Certificate[0])
+	if err != nil {
+		return err
+	}
+	crt.Leaf = leaf
+
+	c.kp.set(crt)
+	if pool != nil {
+		c.kp.setPool(pool)
+	}
+
+	c.log.WithFields(log.Fields{
+		"subject": leaf.Subject.String(),
+		"expires": leaf.NotAfter,
+	}).Info("loaded tls certificate")
+
+	return nil
+}
+
+// watch reloads the files until close is called.  Directories are watched rather than files, since
+// mounted secrets are replaced by swapping a symlink.
+func (c *certFiles) watch() error {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for _, f := range []string{c.cert, c.key, c.ca} {
+		if f == "" || dirs[filepath.Dir(f)] {
+			continue
+		}
+		dirs[filepath.Dir(f)] = true
+		if err := fs.Add(filepath.Dir(f)); err != nil {
+			_ = fs.Close()
+			return err
+		}
+	}
+
+	c.fs = fs
+	c.done = make(chan struct{})
+
+	go c.run()
+
+	return nil
+}
+
+func (c *certFiles) run() {
+	t := time.NewTimer(certSettle)
+	t.Stop()
+	defer t.Stop()
+
+	var tick <-chan time.Time
+	if c.interval > 0 {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick:
+			c.reload()
+		case <-t.C:
+			c.reload()
+		case ev, ok := <-c.fs.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&fsnotify.Chmod == ev.Op {
+				continue
+			}
+			t.Reset(certSettle)
+		case err, ok := <-c.fs.Errors:
+			if !ok {
+				return
+			}
+			c.log.Errorf("watching tls certificate files: %v", err)
+		}
+	}
+}
+
+// reload keeps the current certificate if the files can't be loaded, for instance halfway through
+// a rotation.
+func (c *certFiles) reload() {
+	if err := c.load(); err != nil {
+		c.log.Errorf("can't reload tls certificate: %v", err)
+	}
+}
+
+func (c *certFiles) close() error {
+	if c.fs == nil {
+		return nil
+	}
+
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		err = c.fs.Close()
+	})
+	return err
+}
@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+)
+
+func TestCertificateFilesRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []server.Option
+		addr func(*server.Server) net.Addr
+	}{
+		{
+			name: "grpc",
+			addr: (*server.Server).Address,
+		},
+		{
+			name: "passthrough",
+			opts: []server.Option{server.WithHTTPPassthrough()},
+			addr: (*server.Server).HTTPAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			crt, key := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+			writeKeypair(t, crt, key, 1)
+
+			srv, err := server.New(append(tt.opts,
+				server.WithCertificateFiles(crt, key, ""),
+				server.WithListenAddress("127.0.0.1:0"),
+				server.WithoutSignalHandling(),
+			)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.Start()
+			defer srv.Stop(context.Background())
+
+			addr := tt.addr(srv).String()
+
+			if got := servingSerial(t, addr); got != 1 {
+				t.Fatalf("serial = %d, want 1", got)
+			}
+
+			writeKeypair(t, crt, key, 2)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for servingSerial(t, addr) != 2 {
+				if time.Now().After(deadline) {
+					t.Fatal("rotated certificate was not served to new connections")
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// servingSerial returns the serial number of the certificate presented by a new connection to addr.
+func servingSerial(t *testing.T, addr string) int64 {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		//nolint -- the certificates are self-signed
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+// writeKeypair writes a self-signed certificate with serial and its key.
+func writeKeypair(t *testing.T, crtPath, keyPath string, serial int64) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(crtPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy controls which browser origins may call the HTTP passthrough.
+type CORSPolicy struct {
+	// AllowedOrigins may contain "*" to allow any origin, or a single wildcard such as
+	// "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedHeaders may contain "*" to allow any request header.
+	AllowedHeaders []string
+	AllowedMethods []string
+	// ExposedHeaders are the response headers browsers let clients read.
+	ExposedHeaders []string
+	// AllowCredentials can't be combined with the "*" origin, which would hand credentials to any site.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// defaultCORSPolicy allows any origin, as the passthrough always has.
+var defaultCORSPolicy = CORSPolicy{
+	AllowedOrigins: []string{"*"},
+	AllowedHeaders: []string{"Content-Type", "Accept"},
+	AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "DELETE"},
+}
+
+// validate rejects a policy that would allow any origin to make credentialed requests.
+func (p CORSPolicy) validate() error {
+	if p.AllowCredentials && contains(p.AllowedOrigins, "*") {
+		return errors.New("cors: credentials can't be allowed for the * origin, list the origins instead")
+	}
+	return nil
+}
+
+// handler answers preflight requests and rejects requests from origins p doesn't allow.
+func (p CORSPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		if !p.allowsOrigin(origin) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.preflight(w, r, origin)
+			return
+		}
+
+		p.allow(w, origin)
+		if len(p.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p CORSPolicy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !contains(p.AllowedMethods, method) {
+		http.Error(w, "method not allowed", http.StatusForbidden)
+		return
+	}
+
+	headers := splitHeader(r.Header.Get("Access-Control-Request-Headers"))
+	for _, h := range headers {
+		if !contains(p.AllowedHeaders, "*") && !contains(p.AllowedHeaders, h) {
+			http.Error(w, "header "+h+" not allowed", http.StatusForbidden)
+			return
+		}
+	}
+
+	p.allow(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+
+	allowed := p.AllowedHeaders
+	if contains(allowed, "*") {
+		allowed = headers
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowed, ", "))
+	}
+
+	if p.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p CORSPolicy) allow(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+
+		i := strings.Index(o, "*")
+		if i < 0 {
+			continue
+		}
+
+		prefix, suffix := strings.ToLower(o[:i]), strings.ToLower(o[i+1:])
+		origin := strings.ToLower(origin)
+		if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withGRPCWeb returns p, also allowing the request headers grpc-web clients send and exposing the
+// trailers.
+func (p CORSPolicy) withGRPCWeb() CORSPolicy {
+	add := func(list []string, values ...string) []string {
+		out := append([]string(nil), list...)
+		for _, v := range values {
+			if !contains(out, v) {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+
+	if !contains(p.AllowedHeaders, "*") {
+		p.AllowedHeaders = add(p.AllowedHeaders, append([]string{"Content-Type"}, grpcWebHeaders...)...)
+	}
+	p.ExposedHeaders = add(p.ExposedHeaders, grpcWebTrailers[:2]...)
+
+	return p
+}
+
+// contains reports whether list contains s, ignoring case.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeader splits a comma separated header value.
+func splitHeader(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
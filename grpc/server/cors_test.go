@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	credentialed := CORSPolicy{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowedMethods:   []string{http.MethodPost},
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		name        string
+		policy      CORSPolicy
+		method      string
+		origin      string
+		preflight   string
+		headers     string
+		code        int
+		allowOrigin string
+		credentials string
+	}{
+		{
+			name:   "no origin",
+			policy: credentialed,
+			method: http.MethodGet,
+			code:   http.StatusOK,
+		},
+		{
+			name:        "any origin",
+			policy:      defaultCORSPolicy,
+			method:      http.MethodGet,
+			origin:      "https://anywhere.test",
+			code:        http.StatusOK,
+			allowOrigin: "https://anywhere.test",
+		},
+		{
+			name:        "wildcard origin with credentials",
+			policy:      credentialed,
+			method:      http.MethodPost,
+			origin:      "https://app.example.com",
+			code:        http.StatusOK,
+			allowOrigin: "https://app.example.com",
+			credentials: "true",
+		},
+		{
+			name:   "origin not allowed",
+			policy: credentialed,
+			method: http.MethodPost,
+			origin: "https://example.com.evil.test",
+			code:   http.StatusForbidden,
+		},
+		{
+			name:        "preflight",
+			policy:      credentialed,
+			method:      http.MethodOptions,
+			origin:      "https://app.example.com",
+			preflight:   http.MethodPost,
+			headers:     "authorization",
+			code:        http.StatusNoContent,
+			allowOrigin: "https://app.example.com",
+			credentials: "true",
+		},
+		{
+			name:      "preflight method not allowed",
+			policy:    credentialed,
+			method:    http.MethodOptions,
+			origin:    "https://app.example.com",
+			preflight: http.MethodDelete,
+			code:      http.StatusForbidden,
+		},
+		{
+			name:      "preflight header not allowed",
+			policy:    credentialed,
+			method:    http.MethodOptions,
+			origin:    "https://app.example.com",
+			preflight: http.MethodPost,
+			headers:   "x-secret",
+			code:      http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			if tt.headers != "" {
+				r.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+
+			w := httptest.NewRecorder()
+			tt.policy.handler(ok).ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d", w.Code, tt.code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.credentials)
+			}
+		})
+	}
+}
+
+func TestCORSCredentialsForAnyOrigin(t *testing.T) {
+	_, err := New(
+		WithInsecureSkipVerify(),
+		WithoutSignalHandling(),
+		WithHTTPPassthroughInsecure(),
+		WithCORS(CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}),
+	)
+	if err == nil {
+		t.Fatal("New accepted credentials for the * origin")
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// The gateway forwards who the HTTP caller is under these metadata keys, since its own connection
+// presents the server's certificate and comes from the loopback.  They are only read on calls made by
+// the gateway in process, and incomingHeaderMatcher keeps HTTP callers from setting them.
+const (
+	// gatewayIdentityKey carries the identity of an HTTP caller presenting a verified certificate.
+	gatewayIdentityKey = "hugh-gateway-identity-bin"
+	// gatewayPeerKey carries the IP address of the HTTP caller.
+	gatewayPeerKey = "hugh-gateway-peer"
+)
+
+// fromGateway reports whether the call of ctx was made by the gateway.
+func fromGateway(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	_, ok = p.Addr.(gatewayAddr)
+	return ok
+}
+
+// forwarded reports whether the call of ctx was made by the gateway over the in-process loopback, so
+// that what it forwards about the HTTP caller can be trusted.  Any local process can connect to a unix
+// socket or TCP loopback and claim to be the gateway.
+func forwarded(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	addr, ok := p.Addr.(gatewayAddr)
+	return ok && addr.inProcess
+}
+
+// gatewayMetadata forwards the address of HTTP callers, and the identity of those presenting a
+// verified client certificate.
+func gatewayMetadata(_ context.Context, r *http.Request) metadata.MD {
+	md := metadata.MD{}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.Set(gatewayPeerKey, host)
+	}
+
+	if r.TLS != nil {
+		if id, ok := tlsIdentity(*r.TLS); ok {
+			if b, err := json.Marshal(id); err == nil {
+				md.Set(gatewayIdentityKey, string(b))
+			}
+		}
+	}
+
+	return md
+}
+
+// gatewayHeader reports whether the metadata key is one only the gateway may set.
+func gatewayHeader(key string) bool {
+	return strings.EqualFold(key, gatewayIdentityKey) || strings.EqualFold(key, gatewayPeerKey)
+}
+
+// gatewayIdentity returns the identity the gateway forwarded for the HTTP caller.  Callers check
+// forwarded first.
+func gatewayIdentity(ctx context.Context) (Identity, bool) {
+	v, ok := gatewayValue(ctx, gatewayIdentityKey)
+	if !ok {
+		return Identity{}, false
+	}
+
+	var id Identity
+	if err := json.Unmarshal([]byte(v), &id); err != nil {
+		return Identity{}, false
+	}
+	return id, true
+}
+
+// gatewayPeer returns the IP address the gateway forwarded for the HTTP caller.
+func gatewayPeer(ctx context.Context) (string, bool) {
+	return gatewayValue(ctx, gatewayPeerKey)
+}
+
+func gatewayValue(ctx context.Context, key string) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vals := md.Get(key); len(vals) == 1 {
+		return vals[0], true
+	}
+	return "", false
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame carrying the trailers at the end of a grpc-web response body.
+	grpcWebTrailerFlag = 0x80
+)
+
+var (
+	// grpcWebHeaders are sent by grpc-web clients, and are allowed by the CORS policy with WithGRPCWeb.
+	grpcWebHeaders = []string{"X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}
+
+	// grpcWebTrailers are set by the gRPC server after the body, and are sent in the trailer frame.
+	grpcWebTrailers = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// isGRPCWeb reports whether r is a grpc-web request, in binary or text mode.
+func isGRPCWeb(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// grpcWebHandler translates grpc-web requests into gRPC requests for grpcServer, and passes other
+// requests to next.
+func grpcWebHandler(grpcServer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isGRPCWeb(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ct := r.Header.Get("Content-Type")
+		text := strings.HasPrefix(ct, grpcWebTextContentType)
+
+		// Keep the codec, such as +proto.
+		subtype := strings.TrimPrefix(ct, grpcWebContentType)
+		if text {
+			subtype = strings.TrimPrefix(ct, grpcWebTextContentType)
+		}
+
+		req := r.Clone(r.Context())
+		req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2"
+		req.Header.Set("Content-Type", "application/grpc"+subtype)
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+		if text {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{base64.NewDecoder(base64.StdEncoding, r.Body), r.Body}
+		}
+
+		gw := &grpcWebResponse{
+			w:           w,
+			header:      make(http.Header),
+			contentType: ct,
+			text:        text,
+		}
+		grpcServer.ServeHTTP(gw, req)
+		gw.finish()
+	})
+}
+
+// grpcWebResponse turns the response of the gRPC server into a grpc-web response: the headers are sent
+// as they are, the messages are base64 encoded in text mode, and the trailers are sent in a frame at
+// the end of the body.
+type grpcWebResponse struct {
+	w           http.ResponseWriter
+	header      http.Header
+	contentType string
+	text        bool
+	wroteHeader bool
+}
+
+func (gw *grpcWebResponse) Header() http.Header {
+	return gw.header
+}
+
+func (gw *grpcWebResponse) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+
+	h := gw.w.Header()
+	for k, vv := range gw.header {
+		if k == "Trailer" || strings.HasPrefix(k, http2.TrailerPrefix) || vv == nil {
+			continue
+		}
+		h[k] = vv
+	}
+	h.Set("Content-Type", gw.contentType)
+	h.Del("Content-Length")
+
+	gw.w.WriteHeader(code)
+}
+
+func (gw *grpcWebResponse) Write(b []byte) (int, error) {
+	gw.WriteHeader(http.StatusOK)
+
+	if !gw.text {
+		return gw.w.Write(b)
+	}
+
+	// Every write is encoded on its own, so that streamed messages can be decoded as they arrive.
+	if _, err := io.WriteString(gw.w, base64.StdEncoding.EncodeToString(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (gw *grpcWebResponse) Flush() {
+	gw.WriteHeader(http.StatusOK)
+
+	if f, ok := gw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailer frame.
+func (gw *grpcWebResponse) finish() {
+	var trailers bytes.Buffer
+	for k, vv := range gw.header {
+		name := strings.TrimPrefix(k, http2.TrailerPrefix)
+		if name == k && !contains(grpcWebTrailers, k) {
+			continue
+		}
+		for _, v := range vv {
+			trailers.WriteString(strings.ToLower(name) + ": " + v + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+trailers.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailers.Len()))
+	frame = append(frame, trailers.Bytes()...)
+
+	_, _ = gw.Write(frame)
+	gw.Flush()
+}
@@ -0,0 +1,208 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	"github.com/golang/protobuf/proto"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCWeb(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		text        bool
+	}{
+		{
+			name:        "binary",
+			contentType: "application/grpc-web+proto",
+		},
+		{
+			name:        "text",
+			contentType: "application/grpc-web-text+proto",
+			text:        true,
+		},
+	}
+
+	srv := servertest.New(t, func(s *server.Server) {
+		grpcecho.RegisterEchoServiceServer(s.Transport(), grpcecho.Echo{})
+	}, server.WithHTTPPassthroughInsecure(), server.WithGRPCWeb())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := frame(t, 0, &grpcecho.EchoMessage{Value: "web"})
+			if tt.text {
+				body = []byte(base64.StdEncoding.EncodeToString(body))
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/grpcecho.EchoService/Echo", bytes.NewReader(body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Origin", "https://example.com")
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, r)
+
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := w.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, "Grpc-Status") {
+				t.Errorf("Access-Control-Expose-Headers = %q, want Grpc-Status", got)
+			}
+
+			out := w.Body.Bytes()
+			if tt.text {
+				out = decodeChunks(t, string(out))
+			}
+			frames := readFrames(t, bytes.NewReader(out))
+			if len(frames) != 2 {
+				t.Fatalf("got %d frames, want a message and the trailers", len(frames))
+			}
+
+			var msg grpcecho.EchoMessage
+			if err := proto.Unmarshal(frames[0].data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Value != "web" {
+				t.Errorf("message = %q, want %q", msg.Value, "web")
+			}
+
+			if frames[1].flag != 0x80 || !strings.Contains(string(frames[1].data), "grpc-status: 0\r\n") {
+				t.Errorf("trailers = %x %q, want grpc-status: 0", frames[1].flag, frames[1].data)
+			}
+		})
+	}
+}
+
+func TestGRPCWebStreaming(t *testing.T) {
+	srv := servertest.New(t, nil,
+		server.WithHTTPPassthroughInsecure(),
+		server.WithGRPCWeb(),
+		server.WithHealthService(),
+	)
+
+	hs := httptest.NewServer(srv.Handler)
+	defer hs.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	body := frame(t, 0, &healthpb.HealthCheckRequest{})
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, hs.URL+"/grpc.health.v1.Health/Watch", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc-web+proto")
+
+	resp, err := hs.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Watch doesn't end, so the first message must arrive before the response is complete.
+	f := readFrame(t, bufio.NewReader(resp.Body))
+
+	var msg healthpb.HealthCheckResponse
+	if err := proto.Unmarshal(f.data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("status = %s, want SERVING", msg.Status)
+	}
+}
+
+func TestGRPCWebPreflight(t *testing.T) {
+	srv := servertest.New(t, nil, server.WithHTTPPassthroughInsecure(), server.WithGRPCWeb())
+
+	r := httptest.NewRequest(http.MethodOptions, "/grpcecho.EchoService/Echo", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-user-agent")
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight = %d %s, want 204", w.Code, w.Body)
+	}
+}
+
+type webFrame struct {
+	flag byte
+	data []byte
+}
+
+func frame(t *testing.T, flag byte, m proto.Message) []byte {
+	t.Helper()
+
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, 5, 5+len(b))
+	out[0] = flag
+	binary.BigEndian.PutUint32(out[1:], uint32(len(b)))
+	return append(out, b...)
+}
+
+func readFrame(t *testing.T, r io.Reader) webFrame {
+	t.Helper()
+
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+	return webFrame{flag: hdr[0], data: data}
+}
+
+func readFrames(t *testing.T, r *bytes.Reader) []webFrame {
+	t.Helper()
+
+	var frames []webFrame
+	for r.Len() > 0 {
+		frames = append(frames, readFrame(t, r))
+	}
+	return frames
+}
+
+// decodeChunks decodes a grpc-web-text body, which is made of separately padded base64 chunks.
+func decodeChunks(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var out []byte
+	for s != "" {
+		i := strings.Index(s, "=")
+		for i >= 0 && i+1 < len(s) && s[i+1] == '=' {
+			i++
+		}
+		chunk := s
+		if i >= 0 {
+			chunk, s = s[:i+1], s[i+1:]
+		} else {
+			s = ""
+		}
+
+		b, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(chunk)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b...)
+	}
+	return out
+}
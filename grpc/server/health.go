@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultHealthInterval = 5 * time.Second
+
+// HealthCheck reports whether a dependency is usable.  (*sql.DB).PingContext is a HealthCheck.
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// healthService backs grpc.health.v1.Health, /healthz and /readyz with the server state and the
+// registered checks.  The overall status is reported under the empty service name, and each check
+// under its own name.
+type healthService struct {
+	srv      *Server
+	grpc     *health.Server
+	interval time.Duration
+	mu       sync.RWMutex
+	checks   []namedCheck
+	results  map[string]error
+	ready    bool
+	done     chan struct{}
+	started  sync.Once
+	stopped  sync.Once
+}
+
+func newHealthService(s *Server, interval time.Duration, checks []namedCheck) *healthService {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+
+	h := healthService{
+		srv:      s,
+		grpc:     health.NewServer(),
+		interval: interval,
+		checks:   checks,
+		results:  make(map[string]error),
+		done:     make(chan struct{}),
+	}
+
+	h.grpc.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	for _, c := range checks {
+		h.grpc.SetServingStatus(c.name, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return &h
+}
+
+// addCheck registers a check while the server is running.
+func (h *healthService) addCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+	h.mu.Unlock()
+	h.grpc.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// stateChanged is called by the server on every state change.
+func (h *healthService) stateChanged(st State) {
+	switch st {
+	case Ready:
+		// Ready is entered from the first Accept, which must not wait on the checks.
+		h.started.Do(func() { go h.run() })
+	case Stopping, Terminating, Stopped, Error:
+		h.mu.Lock()
+		h.ready = false
+		h.mu.Unlock()
+		h.grpc.Shutdown()
+	}
+}
+
+func (h *healthService) run() {
+	t := time.NewTicker(h.interval)
+	defer t.Stop()
+
+	h.update()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-t.C:
+			h.update()
+		}
+	}
+}
+
+// stop ends the checks.  Stops may race, for instance a signal and an explicit Stop.
+func (h *healthService) stop() {
+	h.stopped.Do(func() { close(h.done) })
+}
+
+// update runs every check and publishes the results.
+func (h *healthService) update() {
+	h.mu.RLock()
+	checks := h.checks
+	h.mu.RUnlock()
+
+	results := make(map[string]error, len(checks))
+	ready := h.srv.State() == Ready
+
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+		err := c.check(ctx)
+		cancel()
+
+		results[c.name] = err
+		h.grpc.SetServingStatus(c.name, servingStatus(err == nil))
+		if err != nil {
+			ready = false
+			h.srv.log.Warnf("health check %q failed: %v", c.name, err)
+		}
+	}
+
+	h.mu.Lock()
+	// The state may have moved on while the checks were running.
+	ready = ready && h.srv.State() == Ready
+	h.results = results
+	h.ready = ready
+	h.mu.Unlock()
+
+	h.grpc.SetServingStatus("", servingStatus(ready))
+}
+
+func servingStatus(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	State  string            `json:"state"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthz reports liveness: the server has not failed or stopped.
+func (h *healthService) healthz(w http.ResponseWriter, _ *http.Request) {
+	st := h.srv.State()
+	ok := st != Error && st != Stopped
+
+	writeHealth(w, ok, healthResponse{State: st.String()})
+}
+
+// readyz reports readiness: the server is Ready and every check passed.
+func (h *healthService) readyz(w http.ResponseWriter, _ *http.Request) {
+	h.mu.RLock()
+	ok := h.ready
+	resp := healthResponse{
+		State:  h.srv.State().String(),
+		Checks: make(map[string]string, len(h.results)),
+	}
+	for name, err := range h.results {
+		resp.Checks[name] = "ok"
+		if err != nil {
+			resp.Checks[name] = err.Error()
+		}
+	}
+	h.mu.RUnlock()
+
+	writeHealth(w, ok, resp)
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, resp healthResponse) {
+	code := http.StatusOK
+	resp.Status = servingStatus(ok).String()
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
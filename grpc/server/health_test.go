@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type healthBody struct {
+	Status string            `json:"status"`
+	State  string            `json:"state"`
+	Checks map[string]string `json:"checks"`
+}
+
+func TestHealth(t *testing.T) {
+	var failing atomic.Value
+	failing.Store(false)
+
+	check := func(context.Context) error {
+		if failing.Load().(bool) {
+			return errors.New("db unreachable")
+		}
+		return nil
+	}
+
+	srv := servertest.New(t, nil,
+		server.WithHTTPPassthroughInsecure(),
+		server.WithHealthCheck("db", check),
+		server.WithHealthInterval(10*time.Millisecond),
+	)
+
+	client := healthpb.NewHealthClient(srv.Conn)
+
+	tests := []struct {
+		name    string
+		failing bool
+		ready   int
+		overall healthpb.HealthCheckResponse_ServingStatus
+		db      healthpb.HealthCheckResponse_ServingStatus
+		check   string
+	}{
+		{
+			name:    "passing",
+			ready:   http.StatusOK,
+			overall: healthpb.HealthCheckResponse_SERVING,
+			db:      healthpb.HealthCheckResponse_SERVING,
+			check:   "ok",
+		},
+		{
+			name:    "failing",
+			failing: true,
+			ready:   http.StatusServiceUnavailable,
+			overall: healthpb.HealthCheckResponse_NOT_SERVING,
+			db:      healthpb.HealthCheckResponse_NOT_SERVING,
+			check:   "db unreachable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing.Store(tt.failing)
+
+			var body healthBody
+			eventually(t, func() bool {
+				code := get(t, srv.Handler, "/readyz", &body)
+				return code == tt.ready && body.Checks["db"] == tt.check
+			})
+
+			if body.Status != tt.overall.String() || body.State != server.Ready.String() {
+				t.Errorf("/readyz = %+v, want status %s in state %s", body, tt.overall, server.Ready)
+			}
+
+			if code := get(t, srv.Handler, "/healthz", &body); code != http.StatusOK {
+				t.Errorf("/healthz = %d, want %d", code, http.StatusOK)
+			}
+
+			for service, want := range map[string]healthpb.HealthCheckResponse_ServingStatus{"": tt.overall, "db": tt.db} {
+				eventually(t, func() bool {
+					resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+					if err != nil {
+						t.Fatal(err)
+					}
+					return resp.Status == want
+				})
+			}
+		})
+	}
+}
+
+func TestHealthStopping(t *testing.T) {
+	srv := servertest.New(t, nil,
+		server.WithHTTPPassthroughInsecure(),
+		server.WithHealthService(),
+		server.WithHealthInterval(10*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch, err := healthpb.NewHealthClient(srv.Conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status == healthpb.HealthCheckResponse_SERVING {
+			break
+		}
+	}
+
+	stopping := srv.Notify(server.Stopping)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.Stop(context.Background())
+	}()
+	<-stopping
+
+	resp, err := watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status while stopping = %s, want %s", resp.Status, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	var body healthBody
+	if code := get(t, srv.Handler, "/readyz", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while stopping = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	// The open stream holds up the graceful stop.
+	cancel()
+	<-stopped
+
+	if code := get(t, srv.Handler, "/healthz", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz when stopped = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func get(t *testing.T, h http.Handler, path string, body interface{}) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if err := json.Unmarshal(w.Body.Bytes(), body); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	return w.Code
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHealthConcurrentStop stops the server from several goroutines, as a signal and an explicit Stop
+// may.
+func TestHealthConcurrentStop(t *testing.T) {
+	srv := servertest.New(t, nil, server.WithHealthService())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.Stop(context.Background())
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+
+	"github.com/digital-dream-labs/hugh/log"
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is taken from the verified certificate of a mTLS peer.
+type Identity struct {
+	CommonName string
+	DNSNames   []string
+	// URIs holds URI SANs, such as SPIFFE IDs.
+	URIs []string
+}
+
+// Matches reports whether pattern names i.  A pattern is compared with the common name, every DNS name
+// and every URI.  It may end in * to match a prefix, and "*" alone matches any identity.
+func (i Identity) Matches(pattern string) bool {
+	match := func(s string) bool {
+		if p := strings.TrimSuffix(pattern, "*"); p != pattern {
+			return strings.HasPrefix(s, p)
+		}
+		return s == pattern
+	}
+
+	if i.CommonName != "" && match(i.CommonName) {
+		return true
+	}
+	for _, n := range i.DNSNames {
+		if match(n) {
+			return true
+		}
+	}
+	for _, u := range i.URIs {
+		if match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i Identity) String() string {
+	switch {
+	case len(i.URIs) > 0:
+		return i.URIs[0]
+	case i.CommonName != "":
+		return i.CommonName
+	case len(i.DNSNames) > 0:
+		return i.DNSNames[0]
+	default:
+		return ""
+	}
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity of the caller, if the server was constructed with
+// WithPeerIdentity and the caller presented a verified certificate.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// peerIdentity extracts the identity of the peer from its verified certificate chain.
+func peerIdentity(p *peer.Peer) (Identity, bool) {
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return Identity{}, false
+	}
+	return tlsIdentity(info.State)
+}
+
+// tlsIdentity extracts the identity of the client of a TLS connection from its verified certificate
+// chain.
+func tlsIdentity(state tls.ConnectionState) (Identity, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+
+	cert := state.VerifiedChains[0][0]
+	id := Identity{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+
+	return id, true
+}
+
+// identityPolicy puts the identity of mTLS peers in the context and, if it has an allow-list, only
+// lets identities call the methods listed for them.
+type identityPolicy struct {
+	log   log.Logger
+	allow map[string][]string
+}
+
+func (ip *identityPolicy) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := ip.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (ip *identityPolicy) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := ip.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	wrapped := middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+	return handler(srv, wrapped)
+}
+
+func (ip *identityPolicy) authorize(ctx context.Context, method string) (context.Context, error) {
+	p, _ := peer.FromContext(ctx)
+	if p == nil {
+		p = &peer.Peer{}
+	}
+
+	// The gateway connects with the server's own certificate, so its calls take the identity of the
+	// HTTP caller instead.  Over a unix socket or TCP loopback, that identity could be forged by another
+	// local process, so those calls have none.
+	var id Identity
+	var ok bool
+	switch {
+	case forwarded(ctx):
+		id, ok = gatewayIdentity(ctx)
+	case !fromGateway(ctx):
+		id, ok = peerIdentity(p)
+	}
+	if ok {
+		ctx = context.WithValue(ctx, identityKey{}, id)
+	}
+
+	if ip.allow == nil {
+		return ctx, nil
+	}
+
+	fields := log.Fields{
+		"method": method,
+		"peer":   p.Addr,
+	}
+
+	if !ok {
+		ip.log.WithFields(fields).Warn("denied call without a verified client certificate")
+		return ctx, status.Error(codes.Unauthenticated, "a verified client certificate is required")
+	}
+
+	if !ip.allowed(id, method) {
+		fields["identity"] = id.String()
+		ip.log.WithFields(fields).Warn("denied call by identity")
+		return ctx, status.Errorf(codes.PermissionDenied, "%s may not call %s", id, method)
+	}
+
+	return ctx, nil
+}
+
+func (ip *identityPolicy) allowed(id Identity, method string) bool {
+	for pattern, methods := range ip.allow {
+		if !id.Matches(pattern) {
+			continue
+		}
+		for _, m := range methods {
+			if p := strings.TrimSuffix(m, "*"); m == method || (p != m && strings.HasPrefix(method, p)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testCA issues certificates for servers and clients.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name, usable by servers and clients.
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// clientConfig returns the TLS config of a client presenting certs.
+func (ca *testCA) clientConfig(certs ...tls.Certificate) *tls.Config {
+	return &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: certs,
+		ServerName:   "localhost",
+	}
+}
+
+func startIdentityServer(t *testing.T, ca *testCA, opts ...server.Option) *server.Server {
+	t.Helper()
+
+	srv, err := server.New(append(opts,
+		server.WithCertificate(ca.issue(t, "server")),
+		server.WithCertPool(ca.pool),
+		server.WithClientAuth(tls.VerifyClientCertIfGiven),
+		server.WithListenAddress("127.0.0.1:0"),
+		server.WithoutSignalHandling(),
+		// The server's own identity may call anything, which HTTP callers must not inherit through
+		// the gateway.
+		server.WithIdentityAllowList(map[string][]string{
+			"server":   {"/*"},
+			"client-a": {"/grpcecho.EchoService/*"},
+			"client-b": {"/grpc.health.v1.Health/*"},
+		}),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerEcho(t)(srv)
+	srv.Start()
+	t.Cleanup(func() { srv.Stop(context.Background()) })
+
+	return srv
+}
+
+func TestIdentityAllowList(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startIdentityServer(t, ca)
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		want  codes.Code
+	}{
+		{
+			name:  "allowed",
+			certs: []tls.Certificate{ca.issue(t, "client-a")},
+			want:  codes.OK,
+		},
+		{
+			name:  "not allowed",
+			certs: []tls.Certificate{ca.issue(t, "client-b")},
+			want:  codes.PermissionDenied,
+		},
+		{
+			name: "no certificate",
+			want: codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := grpc.DialContext(ctx, srv.Address().String(),
+				grpc.WithTransportCredentials(credentials.NewTLS(ca.clientConfig(tt.certs...))),
+				grpc.WithBlock(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			_, err = grpcecho.NewEchoServiceClient(conn).Echo(ctx, &grpcecho.EchoMessage{Value: "id"})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("Echo() = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityAllowListGateway(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startIdentityServer(t, ca, server.WithHTTPPassthrough())
+
+	// An HTTP caller trying to pass itself off as client-a.
+	forged := base64.StdEncoding.EncodeToString([]byte(`{"CommonName":"client-a"}`))
+
+	tests := []struct {
+		name   string
+		certs  []tls.Certificate
+		header http.Header
+		want   int
+	}{
+		{
+			name:  "allowed",
+			certs: []tls.Certificate{ca.issue(t, "client-a")},
+			want:  http.StatusOK,
+		},
+		{
+			name:  "not allowed",
+			certs: []tls.Certificate{ca.issue(t, "client-b")},
+			want:  http.StatusForbidden,
+		},
+		{
+			name: "no certificate",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:   "forged identity",
+			header: http.Header{"Grpc-Metadata-Hugh-Gateway-Identity-Bin": {forged}},
+			want:   http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := http.Client{
+				Timeout:   5 * time.Second,
+				Transport: &http.Transport{TLSClientConfig: ca.clientConfig(tt.certs...)},
+			}
+
+			r, err := http.NewRequest(http.MethodPost, "https://"+srv.HTTPAddress().String()+"/v1/echo", strings.NewReader(`{"value":"id"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			resp, err := client.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Errorf("POST /v1/echo = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+// TestIdentityForgedOnLoopback calls a unix socket or TCP gateway loopback directly, claiming an
+// identity the way the gateway forwards it.
+func TestIdentityForgedOnLoopback(t *testing.T) {
+	ca := newTestCA(t)
+	forged := metadata.Pairs("hugh-gateway-identity-bin", `{"CommonName":"client-a"}`)
+
+	tests := []struct {
+		name    string
+		gateway server.Option
+	}{
+		{
+			name:    "unix socket",
+			gateway: server.WithGatewayUnixSocket(filepath.Join(t.TempDir(), "gateway.sock")),
+		},
+		{
+			name:    "address",
+			gateway: server.WithGatewayAddress("127.0.0.1:0"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startIdentityServer(t, ca, server.WithHTTPPassthrough(), tt.gateway)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			addr := srv.Address()
+			conn, err := grpc.DialContext(ctx, addr.String(),
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, addr.Network(), addr.String())
+				}),
+				grpc.WithTransportCredentials(credentials.NewTLS(ca.clientConfig(ca.issue(t, "client-b")))),
+				grpc.WithBlock(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			ctx = metadata.NewOutgoingContext(ctx, forged)
+			_, err = grpcecho.NewEchoServiceClient(conn).Echo(ctx, &grpcecho.EchoMessage{Value: "id"})
+			if got := status.Code(err); got != codes.Unauthenticated {
+				t.Errorf("Echo() = %v, want %s", err, codes.Unauthenticated)
+			}
+		})
+	}
+}
@@ -5,48 +5,23 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"os"
-	"os/signal"
-	"runtime"
 	"strings"
-	"syscall"
 
-	"github.com/digital-dream-labs/hugh/log"
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/requestid"
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 )
 
 func (s *Server) changeState(st State) {
 	s.mu.Lock()
 	s.state = st
+	for sub := range s.subs {
+		sub.push(st)
+	}
 	s.mu.Unlock()
 	s.log.Debugf("State changed to %s", st)
-	s.notifyState(st)
-}
-
-func (s *Server) notifyState(st State) {
-	s.mu.RLock()
-	for _, chs := range s.notifyChan[st] {
-		chs <- st
+	if s.health != nil {
+		s.health.stateChanged(st)
 	}
-	s.mu.RUnlock()
-}
-
-// handleSignals allows for clean exits.
-func (s *Server) handleSignals() {
-	ch := make(chan os.Signal, 1)
-
-	signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
-
-	// block until signal is Received
-	x := <-ch
-
-	s.log.WithFields(log.Fields{
-		"signal":              x,
-		"numActiveGoRoutines": runtime.NumGoroutine(),
-	}).Warn("received os signal")
-
-	s.log.Warn("shutting down")
-	s.Stop()
-	s.log.Warn("shut down")
 }
 
 func (s *Server) appendErr(err error) {
@@ -58,38 +33,66 @@ func (s *Server) appendErr(err error) {
 
 func serverTLS(o *options) *tls.Config {
 	//nolint -- This is in place to interact with the older services until they're upgraded...
-	return &tls.Config{
+	c := &tls.Config{
 		//MinVersion:   tls.VersionTLS13,
-		ClientCAs:    o.mustGetCertPool(),
-		Certificates: o.certificates,
-		ClientAuth:   o.clientAuth,
+		ClientCAs:      o.mustGetCertPool(),
+		GetCertificate: o.keypair.getCertificate,
+		ClientAuth:     o.clientAuth,
 	}
+	c.GetConfigForClient = o.keypair.configForClient(c)
+	return c
 }
 
 func grpcHandlerFunc(grpcServer, otherHandler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		ct := r.Header.Get("Content-Type")
+		if r.ProtoMajor == 2 && strings.HasPrefix(ct, "application/grpc") && !strings.HasPrefix(ct, grpcWebContentType) {
 			grpcServer.ServeHTTP(w, r)
 		} else {
-			if origin := r.Header.Get("Origin"); origin != "" {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
-					headers := []string{"Content-Type", "Accept"}
-					w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ","))
-					methods := []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
-					w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
-					return
-				}
-			}
 			otherHandler.ServeHTTP(w, r)
 		}
 	})
 }
 
-func (s *Server) getListener(port int, certs []tls.Certificate) (net.Listener, error) {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return nil, err
+// incomingHeaderMatcher forwards the request ID header to the gRPC server along with the default headers.
+// HTTP callers can't set what the gateway forwards about them.
+func incomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, requestid.Header) {
+		return requestid.Header, true
+	}
+	name, ok := grpc_runtime.DefaultHeaderMatcher(key)
+	if gatewayHeader(name) {
+		return "", false
+	}
+	return name, ok
+}
+
+// outgoingHeaderMatcher returns the request ID to HTTP clients under its own header rather than as
+// Grpc-Metadata-X-Request-Id.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == requestid.Header {
+		return http.CanonicalHeaderKey(requestid.Header), true
+	}
+	return grpc_runtime.MetadataHeaderPrefix + key, true
+}
+
+// getListener opens the public listener on the unix socket, the listen address or the port, in that
+// order, unless WithListener set one.
+func (s *Server) getListener(o *options, kp *keypair) (net.Listener, error) {
+	lis := o.listener
+	if lis == nil {
+		network, address := "tcp", fmt.Sprintf(":%d", o.port)
+		switch {
+		case o.unixSocket != "":
+			network, address = "unix", o.unixSocket
+		case o.listenAddress != "":
+			address = o.listenAddress
+		}
+
+		var err error
+		if lis, err = listen(network, address); err != nil {
+			return nil, err
+		}
 	}
 
 	lis = &internalListener{
@@ -97,18 +100,47 @@ func (s *Server) getListener(port int, certs []tls.Certificate) (net.Listener, e
 		firstAcceptFunc: func() { s.changeState(Ready) },
 	}
 
-	if certs == nil {
+	if kp == nil {
 		return lis, nil
 	}
 
-	tlsListener := tls.NewListener(
-		lis,
-		&tls.Config{
-			MinVersion:   tls.VersionTLS13,
-			Certificates: certs,
-			NextProtos:   []string{"http/1.1"},
-		},
-	)
+	c := &tls.Config{
+		MinVersion:     tls.VersionTLS13,
+		GetCertificate: kp.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	// HTTP callers may present a certificate, whose identity the gateway forwards.  Browsers don't have
+	// one, so it isn't required.
+	if o.identity {
+		c.ClientCAs = o.mustGetCertPool()
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		c.GetConfigForClient = kp.configForClient(c)
+	}
+
+	return tls.NewListener(lis, c), nil
+}
+
+// getLoopback opens the listener the gateway dials to reach the gRPC server.
+func (s *Server) getLoopback(lb loopback) (net.Listener, error) {
+	lis, dial, err := lb.listen()
+	if err != nil {
+		return nil, err
+	}
+	s.gatewayDial = dial
+
+	return &internalListener{
+		Listener:        lis,
+		firstAcceptFunc: func() { s.changeState(Ready) },
+	}, nil
+}
 
-	return tlsListener, nil
+// grpcHTTPHandler serves gRPC calls that arrive on the HTTP passthrough.  They run on handler
+// transports, which GracefulStop can't drain, so Stop waits for them to finish first.
+func (s *Server) grpcHTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.httpCalls.RLock()
+		defer s.httpCalls.RUnlock()
+		s.transport.ServeHTTP(w, r)
+	})
 }
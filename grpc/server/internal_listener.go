@@ -1,12 +1,21 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"os"
 	"sync"
+	"time"
+
+	"google.golang.org/grpc/test/bufconn"
 )
 
 const (
-	internalServerPort = 65533
+	bufconnSize = 1 << 20
+
+	// staleSocketTimeout bounds how long listen checks whether a unix socket is still in use.
+	staleSocketTimeout = time.Second
 )
 
 type internalListener struct {
@@ -31,3 +40,97 @@ func (l *internalListener) Addr() net.Addr {
 func (l *internalListener) Close() error {
 	return l.Listener.Close()
 }
+
+// loopback is how the HTTP gateway reaches the gRPC server when the passthrough is enabled.  By default
+// it's an in-process bufconn, so nothing but the passthrough port is exposed.
+type loopback struct {
+	network string
+	address string
+}
+
+// listen opens the gRPC listener and returns a dialer for the gateway to reach it.
+func (lb loopback) listen() (net.Listener, func(context.Context, string) (net.Conn, error), error) {
+	switch lb.network {
+	case "":
+		lis := bufconn.Listen(bufconnSize)
+		return gatewayListener{Listener: lis, inProcess: true}, func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}, nil
+	}
+
+	lis, err := listen(lb.network, lb.address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	network, address := lb.network, lis.Addr().String()
+	return gatewayListener{Listener: lis}, func(ctx context.Context, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}, nil
+}
+
+// gatewayListener marks the connections the gateway makes over the loopback, so that calls on them
+// are known to be made on behalf of HTTP callers.  Unlike the user agent, the mark can't be forged by
+// other callers.  Only the in-process loopback is out of reach of other processes, so only its
+// connections are trusted with what the gateway forwards about HTTP callers.
+type gatewayListener struct {
+	net.Listener
+	inProcess bool
+}
+
+func (l gatewayListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return gatewayConn{Conn: c, inProcess: l.inProcess}, nil
+}
+
+type gatewayConn struct {
+	net.Conn
+	inProcess bool
+}
+
+// RemoteAddr is what gRPC reports as the peer address of calls on the connection.
+func (c gatewayConn) RemoteAddr() net.Addr {
+	return gatewayAddr{Addr: c.Conn.RemoteAddr(), inProcess: c.inProcess}
+}
+
+// gatewayAddr is the peer address of calls made by the gateway.
+type gatewayAddr struct {
+	net.Addr
+	inProcess bool
+}
+
+// listen is net.Listen, except that it first removes a unix socket left behind by a previous process,
+// which would make Listen fail.
+func listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// removeStaleSocket removes the unix socket at path unless another process still listens on it.  Files
+// that aren't sockets are never removed.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case fi.Mode()&os.ModeSocket == 0:
+		return fmt.Errorf("%s exists and isn't a unix socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, staleSocketTimeout); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
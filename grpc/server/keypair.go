@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+)
+
+// keypair holds the serving certificates and the client CA pool so that they can be replaced while the
+// server is running.
+type keypair struct {
+	mu    sync.RWMutex
+	certs []tls.Certificate
+	pool  *x509.CertPool
+}
+
+func (k *keypair) set(c ...tls.Certificate) {
+	k.mu.Lock()
+	k.certs = c
+	k.mu.Unlock()
+}
+
+func (k *keypair) get() []tls.Certificate {
+	k.mu.RLock()
+	c := k.certs
+	k.mu.RUnlock()
+	return c
+}
+
+func (k *keypair) setPool(p *x509.CertPool) {
+	k.mu.Lock()
+	k.pool = p
+	k.mu.Unlock()
+}
+
+func (k *keypair) getPool() *x509.CertPool {
+	k.mu.RLock()
+	p := k.pool
+	k.mu.RUnlock()
+	return p
+}
+
+// getCertificate implements tls.Config.GetCertificate, preferring the first certificate the client supports.
+func (k *keypair) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := k.get()
+	if len(certs) == 0 {
+		return nil, errors.New("no tls certificate configured")
+	}
+
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+
+	return &certs[0], nil
+}
+
+// getClientCertificate implements tls.Config.GetClientCertificate for connections the server makes to itself.
+func (k *keypair) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certs := k.get()
+	if len(certs) == 0 {
+		return &tls.Certificate{}, nil
+	}
+	return &certs[0], nil
+}
+
+// configForClient returns a tls.Config.GetConfigForClient that verifies clients against the current
+// CA pool, or base's pool if none has been set.
+func (k *keypair) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool := k.getPool()
+		if pool == nil {
+			return nil, nil
+		}
+
+		c := base.Clone()
+		c.ClientCAs = pool
+		c.GetConfigForClient = nil
+		return c, nil
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	defaultHTTPIdleTimeout       = 3 * time.Second
+	defaultHTTPReadHeaderTimeout = 1 * time.Second
+	defaultHTTPMaxHeaderBytes    = 10 << 20
+)
+
+// serverOptions returns the grpc.ServerOptions for the message size, stream and connection limits that
+// were set.  Unset limits keep the grpc defaults.
+func (o *options) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+
+	if o.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(o.maxRecvMsgSize))
+	}
+	if o.maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(o.maxSendMsgSize))
+	}
+	if o.maxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(o.maxConcurrentStreams))
+	}
+	if o.keepalive != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(o.keepalive))
+	}
+	if o.keepalivePolicy != (keepalive.EnforcementPolicy{}) {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(o.keepalivePolicy))
+	}
+
+	return opts
+}
+
+// httpServer returns an http.Server for h with the HTTP timeouts and header limit.
+func (o *options) httpServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		IdleTimeout:       o.httpIdleTimeout,
+		ReadHeaderTimeout: o.httpReadHeaderTimeout,
+		MaxHeaderBytes:    o.httpMaxHeaderBytes,
+	}
+}
+
+// http2Server returns the HTTP/2 settings of the passthrough, with the stream and idle limits that were
+// set.  HTTP/2 servers have no keepalive pings or connection age, so those only apply to the gateway's
+// connection to the gRPC server.
+func (o *options) http2Server() *http2.Server {
+	return &http2.Server{
+		MaxConcurrentStreams: o.maxConcurrentStreams,
+		IdleTimeout:          o.keepalive.MaxConnectionIdle,
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/log"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/keepalive"
+)
+
+func TestLimits(t *testing.T) {
+	type limits struct {
+		maxRecvMsgSize        int
+		maxSendMsgSize        int
+		maxConcurrentStreams  uint32
+		keepalive             keepalive.ServerParameters
+		keepalivePolicy       keepalive.EnforcementPolicy
+		httpIdleTimeout       time.Duration
+		httpReadHeaderTimeout time.Duration
+		httpMaxHeaderBytes    int
+	}
+
+	all := limits{
+		maxRecvMsgSize:       1 << 20,
+		maxSendMsgSize:       2 << 20,
+		maxConcurrentStreams: 10,
+		keepalive: keepalive.ServerParameters{
+			MaxConnectionIdle:     time.Minute,
+			MaxConnectionAge:      time.Hour,
+			MaxConnectionAgeGrace: 10 * time.Second,
+			Time:                  30 * time.Second,
+			Timeout:               5 * time.Second,
+		},
+		keepalivePolicy: keepalive.EnforcementPolicy{
+			MinTime:             15 * time.Second,
+			PermitWithoutStream: true,
+		},
+		httpIdleTimeout:       4 * time.Second,
+		httpReadHeaderTimeout: 2 * time.Second,
+		httpMaxHeaderBytes:    4096,
+	}
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		env   map[string]string
+		want  limits
+		grpc  int
+		http2 *http2.Server
+	}{
+		{
+			name:  "unset",
+			http2: &http2.Server{},
+		},
+		{
+			name: "options",
+			opts: []Option{
+				WithMaxRecvMsgSize(1 << 20),
+				WithMaxSendMsgSize(2 << 20),
+				WithMaxConcurrentStreams(10),
+				WithKeepalive(30*time.Second, 5*time.Second),
+				WithKeepaliveEnforcement(15*time.Second, true),
+				WithMaxConnectionIdle(time.Minute),
+				WithMaxConnectionAge(time.Hour, 10*time.Second),
+				WithHTTPIdleTimeout(4 * time.Second),
+				WithHTTPReadHeaderTimeout(2 * time.Second),
+				WithHTTPMaxHeaderBytes(4096),
+			},
+			want:  all,
+			grpc:  5,
+			http2: &http2.Server{MaxConcurrentStreams: 10, IdleTimeout: time.Minute},
+		},
+		{
+			name: "viper",
+			opts: []Option{WithViper()},
+			env: map[string]string{
+				"DDL_RPC_MAX_RECV_MSG_SIZE":               "1048576",
+				"DDL_RPC_MAX_SEND_MSG_SIZE":               "2097152",
+				"DDL_RPC_MAX_CONCURRENT_STREAMS":          "10",
+				"DDL_RPC_KEEPALIVE_TIME":                  "30s",
+				"DDL_RPC_KEEPALIVE_TIMEOUT":               "5s",
+				"DDL_RPC_KEEPALIVE_MIN_TIME":              "15s",
+				"DDL_RPC_KEEPALIVE_PERMIT_WITHOUT_STREAM": "true",
+				"DDL_RPC_MAX_CONNECTION_IDLE":             "1m",
+				"DDL_RPC_MAX_CONNECTION_AGE":              "1h",
+				"DDL_RPC_MAX_CONNECTION_AGE_GRACE":        "10s",
+				"DDL_RPC_HTTP_IDLE_TIMEOUT":               "4s",
+				"DDL_RPC_HTTP_READ_HEADER_TIMEOUT":        "2s",
+				"DDL_RPC_HTTP_MAX_HEADER_BYTES":           "4096",
+			},
+			want:  all,
+			grpc:  5,
+			http2: &http2.Server{MaxConcurrentStreams: 10, IdleTimeout: time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				setenv(t, k, v)
+			}
+
+			o := options{log: log.Base()}
+			for _, opt := range tt.opts {
+				opt(&o)
+			}
+			if o.errored() {
+				t.Fatal(o.errs)
+			}
+
+			got := limits{
+				maxRecvMsgSize:        o.maxRecvMsgSize,
+				maxSendMsgSize:        o.maxSendMsgSize,
+				maxConcurrentStreams:  o.maxConcurrentStreams,
+				keepalive:             o.keepalive,
+				keepalivePolicy:       o.keepalivePolicy,
+				httpIdleTimeout:       o.httpIdleTimeout,
+				httpReadHeaderTimeout: o.httpReadHeaderTimeout,
+				httpMaxHeaderBytes:    o.httpMaxHeaderBytes,
+			}
+			if got != tt.want {
+				t.Errorf("limits = %+v, want %+v", got, tt.want)
+			}
+
+			if got := len(o.serverOptions()); got != tt.grpc {
+				t.Errorf("%d grpc.ServerOptions, want %d", got, tt.grpc)
+			}
+			if got := o.http2Server(); !reflect.DeepEqual(got, tt.http2) {
+				t.Errorf("http2Server() = %+v, want %+v", got, tt.http2)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	tests := []struct {
+		name string
+		// leave puts something at path, returning a func that cleans it up.
+		leave   func(t *testing.T, path string) func()
+		wantErr bool
+	}{
+		{
+			name:  "nothing",
+			leave: func(*testing.T, string) func() { return func() {} },
+		},
+		{
+			name: "stale socket",
+			leave: func(t *testing.T, path string) func() {
+				lis, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lis.(*net.UnixListener).SetUnlinkOnClose(false)
+				_ = lis.Close()
+				return func() {}
+			},
+		},
+		{
+			name: "socket in use",
+			leave: func(t *testing.T, path string) func() {
+				lis, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return func() { _ = lis.Close() }
+			},
+			wantErr: true,
+		},
+		{
+			name: "file",
+			leave: func(t *testing.T, path string) func() {
+				if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				return func() {}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "server.sock")
+			defer tt.leave(t, path)()
+
+			before, _ := os.Lstat(path)
+
+			lis, err := listen("unix", path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listen() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if after, _ := os.Lstat(path); after == nil || !os.SameFile(before, after) {
+					t.Error("listen() replaced what was at the path")
+				}
+				return
+			}
+			_ = lis.Close()
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	"google.golang.org/grpc"
+)
+
+func TestListeners(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "server.sock")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opt     server.Option
+		network string
+	}{
+		{
+			name:    "listen address",
+			opt:     server.WithListenAddress("127.0.0.1:0"),
+			network: "tcp",
+		},
+		{
+			name:    "unix socket",
+			opt:     server.WithUnixSocket(sock),
+			network: "unix",
+		},
+		{
+			name:    "listener",
+			opt:     server.WithListener(lis),
+			network: "tcp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := server.New(tt.opt, server.WithInsecureSkipVerify(), server.WithoutSignalHandling())
+			if err != nil {
+				t.Fatal(err)
+			}
+			registerEcho(t)(srv)
+			srv.Start()
+			defer srv.Stop(context.Background())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := srv.WaitFor(ctx, server.Ready); err != nil {
+				t.Fatal(err)
+			}
+
+			addr := srv.Address()
+			if addr.Network() != tt.network {
+				t.Errorf("Address().Network() = %s, want %s", addr.Network(), tt.network)
+			}
+
+			conn, err := grpc.DialContext(ctx, addr.String(),
+				grpc.WithContextDialer(func(ctx context.Context, a string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, addr.Network(), a)
+				}),
+				grpc.WithInsecure(),
+				grpc.WithBlock(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := grpcecho.NewEchoServiceClient(conn).Echo(ctx, &grpcecho.EchoMessage{Value: tt.name}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// gatewayUserAgent names the HTTP gateway in the user agent of its calls.  Any client can send it,
+	// so calls are told apart by their connection instead.
+	gatewayUserAgent = "hugh-gateway"
+
+	transportGRPC    = "grpc"
+	transportGateway = "gateway"
+)
+
+// metrics records RPCs handled by the server.  Every series is labeled with the transport, which is
+// "gateway" for requests that came in through the HTTP passthrough and "grpc" otherwise.
+type metrics struct {
+	started  *prometheus.CounterVec
+	handled  *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	received *prometheus.CounterVec
+	sent     *prometheus.CounterVec
+	gatherer prometheus.Gatherer
+}
+
+func (o *options) newMetrics() (*metrics, error) {
+	if o.metricsRegistry != nil {
+		return newMetrics(o.metricsRegistry, o.metricsRegistry)
+	}
+	return newMetrics(prometheus.DefaultRegisterer, prometheus.DefaultGatherer)
+}
+
+func newMetrics(reg prometheus.Registerer, gatherer prometheus.Gatherer) (*metrics, error) {
+	labels := []string{"transport", "grpc_type", "grpc_service", "grpc_method"}
+
+	m := metrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_started_total",
+			Help: "Total number of RPCs started on the server.",
+		}, labels),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Total number of RPCs completed on the server, regardless of success or failure.",
+		}, append(labels, "grpc_code")),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Latency of RPCs handled by the server.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_in_flight",
+			Help: "Number of RPCs currently being handled by the server.",
+		}, labels),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_received_total",
+			Help: "Total number of messages received by the server.",
+		}, labels),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_sent_total",
+			Help: "Total number of messages sent by the server.",
+		}, labels),
+		gatherer: gatherer,
+	}
+
+	// Several servers may share a registry, in which case they share the collectors too.
+	collectors := []struct {
+		c   prometheus.Collector
+		set func(prometheus.Collector)
+	}{
+		{m.started, func(c prometheus.Collector) { m.started = c.(*prometheus.CounterVec) }},
+		{m.handled, func(c prometheus.Collector) { m.handled = c.(*prometheus.CounterVec) }},
+		{m.latency, func(c prometheus.Collector) { m.latency = c.(*prometheus.HistogramVec) }},
+		{m.inFlight, func(c prometheus.Collector) { m.inFlight = c.(*prometheus.GaugeVec) }},
+		{m.received, func(c prometheus.Collector) { m.received = c.(*prometheus.CounterVec) }},
+		{m.sent, func(c prometheus.Collector) { m.sent = c.(*prometheus.CounterVec) }},
+	}
+
+	for _, c := range collectors {
+		err := reg.Register(c.c)
+
+		var are prometheus.AlreadyRegisteredError
+		switch {
+		case errors.As(err, &are):
+			c.set(are.ExistingCollector)
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	return &m, nil
+}
+
+// handler serves the gathered metrics.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+}
+
+// errPanicked is what a call that panicked is recorded as.  The recovery interceptor, which runs
+// around the metrics interceptor, turns the panic into the same code.
+var errPanicked = status.Error(codes.Internal, "panic")
+
+func (m *metrics) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	labels := rpcLabels(ctx, "unary", info.FullMethod)
+	done := m.begin(labels)
+
+	err = errPanicked
+	defer func() { done(err) }()
+
+	m.received.With(labels).Inc()
+	resp, err = handler(ctx, req)
+	if err == nil {
+		m.sent.With(labels).Inc()
+	}
+
+	return resp, err
+}
+
+func (m *metrics) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	typ := "bidi_stream"
+	switch {
+	case info.IsClientStream && !info.IsServerStream:
+		typ = "client_stream"
+	case info.IsServerStream && !info.IsClientStream:
+		typ = "server_stream"
+	}
+
+	labels := rpcLabels(ss.Context(), typ, info.FullMethod)
+	done := m.begin(labels)
+
+	err = errPanicked
+	defer func() { done(err) }()
+
+	return handler(srv, &countingStream{
+		ServerStream: ss,
+		received:     m.received.With(labels),
+		sent:         m.sent.With(labels),
+	})
+}
+
+// begin records the start of an RPC and returns a func recording its end.
+func (m *metrics) begin(labels prometheus.Labels) func(error) {
+	start := time.Now()
+	m.started.With(labels).Inc()
+	m.inFlight.With(labels).Inc()
+
+	return func(err error) {
+		m.inFlight.With(labels).Dec()
+		m.latency.With(labels).Observe(time.Since(start).Seconds())
+
+		handled := prometheus.Labels{"grpc_code": status.Code(err).String()}
+		for k, v := range labels {
+			handled[k] = v
+		}
+		m.handled.With(handled).Inc()
+	}
+}
+
+type countingStream struct {
+	grpc.ServerStream
+	received prometheus.Counter
+	sent     prometheus.Counter
+}
+
+func (s *countingStream) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		s.sent.Inc()
+	}
+	return err
+}
+
+func (s *countingStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		s.received.Inc()
+	}
+	return err
+}
+
+func rpcLabels(ctx context.Context, typ, fullMethod string) prometheus.Labels {
+	service, method := "unknown", "unknown"
+	if parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2); len(parts) == 2 {
+		service, method = parts[0], parts[1]
+	}
+
+	return prometheus.Labels{
+		"transport":    transportOf(ctx),
+		"grpc_type":    typ,
+		"grpc_service": service,
+		"grpc_method":  method,
+	}
+}
+
+// transportOf reports whether the call was made by the HTTP gateway.
+func transportOf(ctx context.Context) string {
+	if fromGateway(ctx) {
+		return transportGateway
+	}
+	return transportGRPC
+}
@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+func registerEcho(t *testing.T) func(*server.Server) {
+	return func(s *server.Server) {
+		grpcecho.RegisterEchoServiceServer(s.Transport(), grpcecho.Echo{})
+		if s.HTTPHandler() == nil {
+			return
+		}
+		if err := s.RegisterHTTPService([]func(context.Context, *grpc_runtime.ServeMux, string, []grpc.DialOption) error{
+			grpcecho.RegisterEchoServiceHandlerFromEndpoint,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	srv := servertest.New(t, registerEcho(t),
+		server.WithHTTPPassthroughInsecure(),
+		server.WithMetricsRegistry(reg),
+	)
+
+	if _, err := grpcecho.NewEchoServiceClient(srv.Conn).Echo(context.Background(), &grpcecho.EchoMessage{Value: "grpc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/echo", strings.NewReader(`{"value":"gateway"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /v1/echo = %d %s", w.Code, w.Body)
+	}
+
+	for _, transport := range []string{"grpc", "gateway"} {
+		labels := map[string]string{
+			"transport":    transport,
+			"grpc_type":    "unary",
+			"grpc_service": "grpcecho.EchoService",
+			"grpc_method":  "Echo",
+		}
+
+		if got := value(t, reg, "grpc_server_started_total", labels); got != 1 {
+			t.Errorf("%s: started = %v, want 1", transport, got)
+		}
+		if got := value(t, reg, "grpc_server_msg_sent_total", labels); got != 1 {
+			t.Errorf("%s: sent = %v, want 1", transport, got)
+		}
+		if got := value(t, reg, "grpc_server_handling_seconds", labels); got != 1 {
+			t.Errorf("%s: latency observations = %v, want 1", transport, got)
+		}
+
+		labels["grpc_code"] = "OK"
+		if got := value(t, reg, "grpc_server_handled_total", labels); got != 1 {
+			t.Errorf("%s: handled = %v, want 1", transport, got)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "grpc_server_handled_total") {
+		t.Errorf("GET /metrics = %d %s", w.Code, w.Body)
+	}
+}
+
+// TestMetricsUserAgent labels a native call that sends the gateway's user agent as grpc.
+func TestMetricsUserAgent(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := startPassthrough(t, server.WithMetricsRegistry(reg))
+
+	conn, err := grpc.Dial(srv.HTTPAddress().String(), grpc.WithInsecure(), grpc.WithUserAgent("hugh-gateway"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	echoGRPC(t, conn, "grpc")
+
+	for transport, want := range map[string]float64{"grpc": 1, "gateway": 0} {
+		labels := map[string]string{
+			"transport":    transport,
+			"grpc_type":    "unary",
+			"grpc_service": "grpcecho.EchoService",
+			"grpc_method":  "Echo",
+		}
+		if got := value(t, reg, "grpc_server_started_total", labels); got != want {
+			t.Errorf("%s: started = %v, want %v", transport, got, want)
+		}
+	}
+}
+
+func TestMetricsPort(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	_ = lis.Close()
+
+	srv := servertest.New(t, registerEcho(t),
+		server.WithHTTPPassthroughInsecure(),
+		server.WithMetricsRegistry(prometheus.NewRegistry()),
+		server.WithMetricsPort(port),
+	)
+
+	if _, err := grpcecho.NewEchoServiceClient(srv.Conn).Echo(context.Background(), &grpcecho.EchoMessage{Value: "grpc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + srv.AdminAddress().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "grpc_server_handled_total") {
+		t.Errorf("GET /metrics on the admin port = %d %s", resp.StatusCode, body)
+	}
+
+	// The passthrough leaves /metrics to the admin port.
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("GET /metrics on the passthrough = %d, want it served on the admin port only", w.Code)
+	}
+}
+
+// value returns the value of the counter, or the sample count of the histogram, called name with
+// labels in reg.
+func value(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
@@ -3,10 +3,16 @@ package server
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"time"
 
 	"github.com/digital-dream-labs/hugh/log"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 // Option provides a function definition to set options
@@ -14,6 +20,7 @@ type Option func(*options)
 
 type options struct {
 	certificates            []tls.Certificate
+	keypair                 *keypair
 	errs                    []error
 	ssInterceptors          []grpc.StreamServerInterceptor
 	usInterceptors          []grpc.UnaryServerInterceptor
@@ -27,6 +34,42 @@ type options struct {
 	reflect                 bool
 	httpPassthrough         bool
 	httpPassthroughInsecure bool
+	health                  bool
+	healthInterval          time.Duration
+	healthChecks            []namedCheck
+	metrics                 bool
+	metricsPort             int
+	metricsRegistry         *prometheus.Registry
+	shutdownTimeout         time.Duration
+	loopback                loopback
+	certFiles               *certFiles
+	certReloadInterval      time.Duration
+	cors                    CORSPolicy
+	noRecovery              bool
+	recoveryHandler         RecoveryHandler
+	requestID               bool
+	identity                bool
+	identityAllow           map[string][]string
+	rateLimits              map[string]RateLimit
+	defaultRateLimit        *RateLimit
+	rateLimitKey            RateLimitKey
+	maxRecvMsgSize          int
+	maxSendMsgSize          int
+	maxConcurrentStreams    uint32
+	keepalive               keepalive.ServerParameters
+	keepalivePolicy         keepalive.EnforcementPolicy
+	httpIdleTimeout         time.Duration
+	httpReadHeaderTimeout   time.Duration
+	httpMaxHeaderBytes      int
+	noSignals               bool
+	shutdownSignals         []os.Signal
+	reloadHooks             []func()
+	listener                net.Listener
+	listenAddress           string
+	unixSocket              string
+	systemd                 bool
+	adminListener           net.Listener
+	grpcWeb                 bool
 }
 
 func (o *options) mustGetCertPool() *x509.CertPool {
@@ -64,6 +107,41 @@ func WithPort(p int) Option {
 	}
 }
 
+// WithListenAddress listens on addr, such as "127.0.0.1:9000", instead of the port on every interface.
+func WithListenAddress(addr string) Option {
+	return func(o *options) {
+		o.listenAddress = addr
+	}
+}
+
+// WithUnixSocket listens on a unix domain socket at path instead of a TCP port.  A socket left at path
+// by a previous process is removed first, but one still in use or a file that isn't a socket is an
+// error.
+func WithUnixSocket(path string) Option {
+	return func(o *options) {
+		o.unixSocket = path
+	}
+}
+
+// WithListener serves on lis, such as a listener inherited from a parent process, instead of opening
+// one.  With the HTTP passthrough, lis serves HTTP and gRPC, which gRPC clients reach over HTTP/2 with TLS
+// or over h2c without it.
+func WithListener(lis net.Listener) Option {
+	return func(o *options) {
+		o.listener = lis
+	}
+}
+
+// WithSystemd serves on the sockets systemd passes through LISTEN_FDS: the first one instead of the port,
+// and the second one, if metrics are enabled, instead of the metrics port.  It also tells systemd about state changes over
+// NOTIFY_SOCKET and pings its watchdog when WATCHDOG_USEC is set.  Without these variables it does
+// nothing.
+func WithSystemd() Option {
+	return func(o *options) {
+		o.systemd = true
+	}
+}
+
 // WithCertPool overrides the system cert pool.
 func WithCertPool(p *x509.CertPool) Option {
 	return func(o *options) {
@@ -140,3 +218,297 @@ func WithTLSKey(s string) Option {
 		o.tlsKey = s
 	}
 }
+
+// WithHealthService registers grpc.health.v1.Health and, with the HTTP passthrough, serves /healthz
+// and /readyz.  Readiness requires the server to be Ready and every health check to pass.
+func WithHealthService() Option {
+	return func(o *options) {
+		o.health = true
+	}
+}
+
+// WithHealthCheck adds a dependency check to the health service, enabling it.
+func WithHealthCheck(name string, check HealthCheck) Option {
+	return func(o *options) {
+		o.health = true
+		o.healthChecks = append(o.healthChecks, namedCheck{name: name, check: check})
+	}
+}
+
+// WithHealthInterval sets how often health checks run.  Default: 5s
+func WithHealthInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.healthInterval = d
+	}
+}
+
+// WithMetrics records Prometheus metrics for every RPC and serves them on /metrics, either on the HTTP
+// passthrough or on the port set by WithMetricsPort.  Calls made through the HTTP gateway are labeled
+// with transport="gateway", native calls with transport="grpc".
+func WithMetrics() Option {
+	return func(o *options) {
+		o.metrics = true
+	}
+}
+
+// WithMetricsPort serves /metrics on a separate admin port instead of the HTTP passthrough, enabling
+// metrics.
+func WithMetricsPort(p int) Option {
+	return func(o *options) {
+		o.metrics = true
+		o.metricsPort = p
+	}
+}
+
+// WithMetricsRegistry registers and gathers metrics with r instead of the default Prometheus registry,
+// enabling metrics.
+func WithMetricsRegistry(r *prometheus.Registry) Option {
+	return func(o *options) {
+		o.metrics = true
+		o.metricsRegistry = r
+	}
+}
+
+// WithShutdownTimeout bounds how long Stop waits for open RPCs and HTTP requests to finish before
+// closing their connections.  Default: 30s
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = d
+	}
+}
+
+// WithoutSignalHandling leaves os signals to the application, which then calls Stop itself.
+func WithoutSignalHandling() Option {
+	return func(o *options) {
+		o.noSignals = true
+	}
+}
+
+// WithShutdownSignals sets the signals that stop the server gracefully.  Without any, no signal stops
+// it.  Default: SIGINT, SIGTERM and SIGQUIT
+func WithShutdownSignals(sig ...os.Signal) Option {
+	return func(o *options) {
+		o.shutdownSignals = sig
+	}
+}
+
+// OnReload calls f whenever the process receives SIGHUP, unless SIGHUP is a shutdown signal.
+func OnReload(f func()) Option {
+	return func(o *options) {
+		o.reloadHooks = append(o.reloadHooks, f)
+	}
+}
+
+// WithGatewayUnixSocket makes the HTTP gateway reach the gRPC server through a unix socket at path,
+// instead of the default in-process connection.  Other local processes could connect to it too, so the
+// identity of HTTP callers isn't forwarded: with an identity allow list, their calls are unauthenticated.
+func WithGatewayUnixSocket(path string) Option {
+	return func(o *options) {
+		o.loopback = loopback{network: "unix", address: path}
+	}
+}
+
+// WithGatewayAddress makes the HTTP gateway reach the gRPC server through a TCP listener on addr,
+// instead of the default in-process connection.  addr should be a loopback address such as
+// "127.0.0.1:0".  As with WithGatewayUnixSocket, the identity of HTTP callers isn't forwarded.
+func WithGatewayAddress(addr string) Option {
+	return func(o *options) {
+		o.loopback = loopback{network: "tcp", address: addr}
+	}
+}
+
+// WithCertificateFiles loads the certificate, key and optional client CA pool from files, and reloads
+// them whenever they change.  The new certificate applies to new connections on the gRPC and
+// passthrough listeners.
+func WithCertificateFiles(certPath, keyPath, caPath string) Option {
+	return func(o *options) {
+		if certPath == "" || keyPath == "" {
+			o.errs = append(o.errs, errors.New("certificate and key files are required"))
+			return
+		}
+
+		o.certFiles = &certFiles{
+			cert: certPath,
+			key:  keyPath,
+			ca:   caPath,
+		}
+	}
+}
+
+// WithCertificateReloadInterval additionally reloads the files set by WithCertificateFiles on an
+// interval, for filesystems that don't report changes.
+func WithCertificateReloadInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.certReloadInterval = d
+	}
+}
+
+// WithGRPCWeb serves grpc-web requests, in binary and text mode, on the HTTP passthrough, so that browsers
+// can call the gRPC services directly.  The CORS policy is extended with the headers grpc-web uses.
+func WithGRPCWeb() Option {
+	return func(o *options) {
+		o.grpcWeb = true
+	}
+}
+
+// WithCORS sets the CORS policy of the HTTP passthrough.  Requests from other origins are rejected with
+// 403 Forbidden.  By default any origin is allowed.
+func WithCORS(p CORSPolicy) Option {
+	return func(o *options) {
+		o.cors = p
+	}
+}
+
+// WithoutRecovery disables the interceptors that turn panics in handlers into codes.Internal errors,
+// so that a panic crashes the process.
+func WithoutRecovery() Option {
+	return func(o *options) {
+		o.noRecovery = true
+	}
+}
+
+// WithRecoveryHandler calls h with every recovered panic, for instance to report it to an error
+// tracker.
+func WithRecoveryHandler(h RecoveryHandler) Option {
+	return func(o *options) {
+		o.recoveryHandler = h
+	}
+}
+
+// WithRequestID installs the requestid interceptors ahead of any others, so that every request has an
+// ID in its context, its log fields and its response headers.
+func WithRequestID() Option {
+	return func(o *options) {
+		o.requestID = true
+	}
+}
+
+// WithPeerIdentity puts the Identity of callers presenting a verified client certificate in the
+// context, see IdentityFromContext.  Use it with WithClientAuth(tls.RequireAndVerifyClientCert).
+func WithPeerIdentity() Option {
+	return func(o *options) {
+		o.identity = true
+	}
+}
+
+// WithIdentityAllowList only lets callers call the methods listed for the identity patterns they match,
+// enabling WithPeerIdentity.  Patterns are matched as by Identity.Matches, and methods such as
+// "/pkg.Service/Method" may end in * to match a prefix:
+//
+//	server.WithIdentityAllowList(map[string][]string{
+//		"spiffe://cluster/ns/billing/*": {"/billing.Ledger/*"},
+//		"*":                             {"/grpc.health.v1.Health/*"},
+//	})
+func WithIdentityAllowList(allow map[string][]string) Option {
+	return func(o *options) {
+		o.identity = true
+		o.identityAllow = allow
+	}
+}
+
+// WithRateLimit limits calls to method, such as "/pkg.Service/Method", by each caller.  A method ending in
+// * sets the limit of every method starting with it.  Calls over the limit get codes.ResourceExhausted
+// with retry-after trailer metadata, or 429 Too Many Requests through the HTTP gateway.
+func WithRateLimit(method string, l RateLimit) Option {
+	return func(o *options) {
+		if o.rateLimits == nil {
+			o.rateLimits = make(map[string]RateLimit)
+		}
+		o.rateLimits[method] = l
+	}
+}
+
+// WithDefaultRateLimit limits calls to methods without a limit of their own.
+func WithDefaultRateLimit(l RateLimit) Option {
+	return func(o *options) {
+		o.defaultRateLimit = &l
+	}
+}
+
+// WithRateLimitKey sets who calls are counted against.  Default: RateLimitByPeerIP
+func WithRateLimitKey(k RateLimitKey) Option {
+	return func(o *options) {
+		o.rateLimitKey = k
+	}
+}
+
+// WithMaxRecvMsgSize sets the largest message in bytes the server accepts.  Default: 4MB
+func WithMaxRecvMsgSize(n int) Option {
+	return func(o *options) {
+		o.maxRecvMsgSize = n
+	}
+}
+
+// WithMaxSendMsgSize sets the largest message in bytes the server sends.  Default: unlimited
+func WithMaxSendMsgSize(n int) Option {
+	return func(o *options) {
+		o.maxSendMsgSize = n
+	}
+}
+
+// WithMaxConcurrentStreams limits the concurrent streams of each client connection, including the HTTP/2
+// and h2c connections of gRPC clients to the HTTP passthrough.
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(o *options) {
+		o.maxConcurrentStreams = n
+	}
+}
+
+// WithKeepalive pings clients after they have been idle for d, and closes the connection if a ping isn't
+// answered within timeout.  It doesn't apply to the HTTP passthrough.  Default: 2h and 20s
+func WithKeepalive(d, timeout time.Duration) Option {
+	return func(o *options) {
+		o.keepalive.Time = d
+		o.keepalive.Timeout = timeout
+	}
+}
+
+// WithKeepaliveEnforcement closes connections of clients pinging more often than every minTime, or
+// pinging without active streams unless permitWithoutStream is set.  It doesn't apply to the HTTP
+// passthrough.  Default: 5m, false
+func WithKeepaliveEnforcement(minTime time.Duration, permitWithoutStream bool) Option {
+	return func(o *options) {
+		o.keepalivePolicy = keepalive.EnforcementPolicy{
+			MinTime:             minTime,
+			PermitWithoutStream: permitWithoutStream,
+		}
+	}
+}
+
+// WithMaxConnectionIdle closes connections without active streams for d, including the HTTP/2 and h2c
+// connections to the HTTP passthrough.
+func WithMaxConnectionIdle(d time.Duration) Option {
+	return func(o *options) {
+		o.keepalive.MaxConnectionIdle = d
+	}
+}
+
+// WithMaxConnectionAge gracefully closes connections older than age, which lets clients spread over new
+// server instances, giving active calls grace to finish.  It doesn't apply to the HTTP passthrough.
+func WithMaxConnectionAge(age, grace time.Duration) Option {
+	return func(o *options) {
+		o.keepalive.MaxConnectionAge = age
+		o.keepalive.MaxConnectionAgeGrace = grace
+	}
+}
+
+// WithHTTPIdleTimeout sets how long the HTTP passthrough keeps idle connections open.  Default: 3s
+func WithHTTPIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.httpIdleTimeout = d
+	}
+}
+
+// WithHTTPReadHeaderTimeout sets how long the HTTP passthrough waits for request headers.  Default: 1s
+func WithHTTPReadHeaderTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.httpReadHeaderTimeout = d
+	}
+}
+
+// WithHTTPMaxHeaderBytes limits the size of request headers on the HTTP passthrough.  Default: 10MB
+func WithHTTPMaxHeaderBytes(n int) Option {
+	return func(o *options) {
+		o.httpMaxHeaderBytes = n
+	}
+}
@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func startPassthrough(t *testing.T, opts ...server.Option) *server.Server {
+	t.Helper()
+
+	srv, err := server.New(append(opts,
+		server.WithInsecureSkipVerify(),
+		server.WithHTTPPassthroughInsecure(),
+		server.WithListenAddress("127.0.0.1:0"),
+		server.WithoutSignalHandling(),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerEcho(t)(srv)
+	srv.Start()
+	t.Cleanup(func() { srv.Stop(context.Background()) })
+
+	return srv
+}
+
+// echoGRPC calls Echo over conn.
+func echoGRPC(t *testing.T, conn *grpc.ClientConn, value string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := grpcecho.NewEchoServiceClient(conn).Echo(ctx, &grpcecho.EchoMessage{Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != value {
+		t.Errorf("Echo() = %q, want %q", resp.Value, value)
+	}
+}
+
+// TestPassthroughServers runs several passthrough servers in one process and calls each through the
+// gateway and natively over h2c.
+func TestPassthroughServers(t *testing.T) {
+	tests := []struct {
+		name string
+		// gateway returns the options of the listener the gateway reaches the gRPC server through.
+		gateway func(t *testing.T) []server.Option
+	}{
+		{
+			name:    "in process",
+			gateway: func(*testing.T) []server.Option { return nil },
+		},
+		{
+			name: "unix socket",
+			gateway: func(t *testing.T) []server.Option {
+				path := filepath.Join(t.TempDir(), "gateway.sock")
+				return []server.Option{server.WithGatewayUnixSocket(path)}
+			},
+		},
+		{
+			name: "address",
+			gateway: func(*testing.T) []server.Option {
+				return []server.Option{server.WithGatewayAddress("127.0.0.1:0")}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var servers []*server.Server
+			for i := 0; i < 2; i++ {
+				servers = append(servers, startPassthrough(t, tt.gateway(t)...))
+			}
+
+			client := http.Client{Timeout: 5 * time.Second}
+			for i, srv := range servers {
+				resp, err := client.Post("http://"+srv.HTTPAddress().String()+"/v1/echo", "application/json", strings.NewReader(`{"value":"gateway"}`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "gateway") {
+					t.Errorf("server %d: POST /v1/echo = %d %s, want %d", i, resp.StatusCode, body, http.StatusOK)
+				}
+
+				conn, err := grpc.Dial(srv.HTTPAddress().String(), grpc.WithInsecure())
+				if err != nil {
+					t.Fatal(err)
+				}
+				echoGRPC(t, conn, "native")
+				conn.Close()
+			}
+		})
+	}
+}
+
+// TestPassthroughTLS calls the passthrough natively, with h2 negotiated over TLS.
+func TestPassthroughTLS(t *testing.T) {
+	ca := newTestCA(t)
+	srv := startIdentityServer(t, ca, server.WithHTTPPassthrough())
+
+	creds := credentials.NewTLS(ca.clientConfig(ca.issue(t, "client-a")))
+	conn, err := grpc.Dial(srv.HTTPAddress().String(), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	echoGRPC(t, conn, "native")
+}
+
+// TestPassthroughStreamLimit holds the only stream a connection to the passthrough may open.
+func TestPassthroughStreamLimit(t *testing.T) {
+	srv := startPassthrough(t, server.WithHealthService(), server.WithMaxConcurrentStreams(1))
+
+	conn, err := grpc.Dial(srv.HTTPAddress().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	health := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := health.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_, err := health.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	if err := check(200 * time.Millisecond); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Check() beside the open stream = %v, want %s", err, codes.DeadlineExceeded)
+	}
+
+	cancel()
+	if err := check(5 * time.Second); err != nil {
+		t.Errorf("Check() once the stream ended = %v", err)
+	}
+}
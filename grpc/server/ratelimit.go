@@ -0,0 +1,245 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// retryAfter is the metadata key telling rate limited callers how many seconds to wait.
+	retryAfter = "retry-after"
+
+	// idleBucket is how long a bucket is kept after its last call.
+	idleBucket = 10 * time.Minute
+
+	// maxBuckets bounds how many buckets are kept.  Beyond it, the least recently used are dropped, so
+	// callers making up keys can't grow memory without bound.
+	maxBuckets = 10000
+)
+
+// RateLimit is a token bucket allowing Rate calls per second on average, in bursts of up to Burst.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitKey returns who a call is made by.  Every caller has its own bucket for each method.
+type RateLimitKey func(ctx context.Context) string
+
+// RateLimitByPeerIP gives every peer IP address its own buckets.  It is the default.  Calls through the
+// in-process gateway are counted against the address of the HTTP caller; over a unix socket or TCP
+// gateway loopback, they share the gateway's.  Callers on a unix socket have no address and share one
+// bucket; tell them apart with RateLimitByIdentity or RateLimitByMetadata.
+func RateLimitByPeerIP(ctx context.Context) string {
+	if forwarded(ctx) {
+		if host, ok := gatewayPeer(ctx); ok {
+			return host
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// RateLimitByIdentity gives every mTLS identity its own buckets, falling back to the peer IP for
+// callers without a verified certificate.
+func RateLimitByIdentity(ctx context.Context) string {
+	var id Identity
+	var ok bool
+	switch {
+	case forwarded(ctx):
+		id, ok = gatewayIdentity(ctx)
+	case !fromGateway(ctx):
+		if p, found := peer.FromContext(ctx); found {
+			id, ok = peerIdentity(p)
+		}
+	}
+	if ok {
+		return id.String()
+	}
+	return RateLimitByPeerIP(ctx)
+}
+
+// RateLimitByMetadata gives every value of the metadata key, such as an API key, its own buckets.
+func RateLimitByMetadata(key string) RateLimitKey {
+	return func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+}
+
+type bucket struct {
+	key     string
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// rateLimiter rejects calls exceeding the limit of their method with codes.ResourceExhausted.
+type rateLimiter struct {
+	limits     map[string]RateLimit
+	def        *RateLimit
+	key        RateLimitKey
+	maxBuckets int
+	mu         sync.Mutex
+	buckets    map[string]*list.Element
+	// lru holds the buckets, most recently used first.
+	lru *list.List
+}
+
+func newRateLimiter(limits map[string]RateLimit, def *RateLimit, key RateLimitKey) *rateLimiter {
+	if key == nil {
+		key = RateLimitByPeerIP
+	}
+
+	return &rateLimiter{
+		limits:     limits,
+		def:        def,
+		key:        key,
+		maxBuckets: maxBuckets,
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (rl *rateLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if wait, ok := rl.allow(ctx, info.FullMethod); !ok {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(retryAfter, seconds(wait)))
+		return nil, exhausted(info.FullMethod, wait)
+	}
+	return handler(ctx, req)
+}
+
+func (rl *rateLimiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if wait, ok := rl.allow(ss.Context(), info.FullMethod); !ok {
+		ss.SetTrailer(metadata.Pairs(retryAfter, seconds(wait)))
+		return exhausted(info.FullMethod, wait)
+	}
+	return handler(srv, ss)
+}
+
+// allow takes a token from the bucket of the caller, or reports how long to wait for one.
+func (rl *rateLimiter) allow(ctx context.Context, method string) (time.Duration, bool) {
+	limit, ok := rl.limitFor(method)
+	if !ok {
+		return 0, true
+	}
+
+	now := time.Now()
+	key := method + "\x00" + rl.key(ctx)
+
+	rl.mu.Lock()
+	b := rl.bucket(key, limit, now)
+	rl.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Second, false
+	}
+	if wait := r.DelayFrom(now); wait > 0 {
+		r.CancelAt(now)
+		return wait, false
+	}
+	return 0, true
+}
+
+// bucket returns the bucket of key, creating it if needed, and drops the buckets idle for too long or
+// beyond maxBuckets.  rl.mu must be held.
+func (rl *rateLimiter) bucket(key string, limit RateLimit, now time.Time) *bucket {
+	var b *bucket
+	if e, ok := rl.buckets[key]; ok {
+		b = e.Value.(*bucket)
+		rl.lru.MoveToFront(e)
+	} else {
+		b = &bucket{key: key, limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		rl.buckets[key] = rl.lru.PushFront(b)
+	}
+	b.seen = now
+
+	for e := rl.lru.Back(); e != nil && e.Value != b; e = rl.lru.Back() {
+		old := e.Value.(*bucket)
+		if rl.lru.Len() <= rl.maxBuckets && now.Sub(old.seen) <= idleBucket {
+			break
+		}
+		rl.lru.Remove(e)
+		delete(rl.buckets, old.key)
+	}
+
+	return b
+}
+
+// limitFor returns the limit of an exact match, then of the longest matching prefix ending in *, then
+// the default limit.
+func (rl *rateLimiter) limitFor(method string) (RateLimit, bool) {
+	if l, ok := rl.limits[method]; ok {
+		return l, true
+	}
+
+	best, found := "", false
+	for pattern := range rl.limits {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix == pattern || !strings.HasPrefix(method, prefix) {
+			continue
+		}
+		if !found || len(prefix) > len(best) {
+			best, found = prefix, true
+		}
+	}
+
+	switch {
+	case found:
+		return rl.limits[best+"*"], true
+	case rl.def != nil:
+		return *rl.def, true
+	default:
+		return RateLimit{}, false
+	}
+}
+
+func exhausted(method string, wait time.Duration) error {
+	return status.Errorf(codes.ResourceExhausted, "rate limit of %s exceeded, retry in %s", method, wait.Round(time.Millisecond))
+}
+
+// seconds rounds d up to whole seconds, as Retry-After requires.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// gatewayErrorHandler sets Retry-After on the 429 Too Many Requests the gateway answers rate limited
+// calls with.
+func gatewayErrorHandler(ctx context.Context, mux *grpc_runtime.ServeMux, m grpc_runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	if status.Code(err) == codes.ResourceExhausted {
+		if md, ok := grpc_runtime.ServerMetadataFromContext(ctx); ok {
+			if v := md.TrailerMD.Get(retryAfter); len(v) > 0 {
+				w.Header().Set("Retry-After", v[0])
+			}
+		}
+	}
+
+	grpc_runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// TestRateLimitBuckets drops the least recently used buckets beyond the limit.
+func TestRateLimitBuckets(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []string
+		// last is whether the last call is allowed.
+		last bool
+	}{
+		{
+			name:  "kept",
+			calls: []string{"a", "b", "a"},
+			last:  false,
+		},
+		{
+			name:  "used recently",
+			calls: []string{"a", "b", "a", "c", "a"},
+			last:  false,
+		},
+		{
+			name:  "least recently used",
+			calls: []string{"a", "b", "c", "a"},
+			last:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newRateLimiter(nil, &RateLimit{Rate: 0.1, Burst: 1}, RateLimitByMetadata("x-api-key"))
+			rl.maxBuckets = 2
+
+			var allowed bool
+			for _, key := range tt.calls {
+				ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", key))
+				_, allowed = rl.allow(ctx, "/grpcecho.EchoService/Echo")
+			}
+
+			if allowed != tt.last {
+				t.Errorf("last call allowed = %v, want %v", allowed, tt.last)
+			}
+			if got := len(rl.buckets); got > rl.maxBuckets || got != rl.lru.Len() {
+				t.Errorf("%d buckets, %d in use order, want at most %d", got, rl.lru.Len(), rl.maxBuckets)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// oneCall allows a single call every ten seconds.
+var oneCall = server.RateLimit{Rate: 0.1, Burst: 1}
+
+func TestRateLimit(t *testing.T) {
+	srv := servertest.New(t, registerEcho(t), server.WithRateLimit("/grpcecho.EchoService/*", oneCall))
+
+	echo := func() (metadata.MD, error) {
+		var trailer metadata.MD
+		_, err := grpcecho.NewEchoServiceClient(srv.Conn).Echo(context.Background(), &grpcecho.EchoMessage{Value: "limited"}, grpc.Trailer(&trailer))
+		return trailer, err
+	}
+
+	if _, err := echo(); err != nil {
+		t.Fatal(err)
+	}
+
+	trailer, err := echo()
+	if got := status.Code(err); got != codes.ResourceExhausted {
+		t.Fatalf("Echo() = %v, want %s", err, codes.ResourceExhausted)
+	}
+	if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != "10" {
+		t.Errorf("retry-after = %q, want 10", got)
+	}
+}
+
+func TestRateLimitByMetadata(t *testing.T) {
+	srv := servertest.New(t, registerEcho(t),
+		server.WithDefaultRateLimit(oneCall),
+		server.WithRateLimitKey(server.RateLimitByMetadata("x-api-key")),
+	)
+
+	tests := []struct {
+		key  string
+		want codes.Code
+	}{
+		{key: "a", want: codes.OK},
+		{key: "a", want: codes.ResourceExhausted},
+		{key: "b", want: codes.OK},
+	}
+
+	for _, tt := range tests {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", tt.key)
+		_, err := grpcecho.NewEchoServiceClient(srv.Conn).Echo(ctx, &grpcecho.EchoMessage{Value: "limited"})
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("Echo() with key %s = %v, want %s", tt.key, err, tt.want)
+		}
+	}
+}
+
+func TestRateLimitGateway(t *testing.T) {
+	srv := servertest.New(t, registerEcho(t),
+		server.WithHTTPPassthroughInsecure(),
+		server.WithRateLimit("/grpcecho.EchoService/Echo", oneCall),
+	)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       int
+	}{
+		{
+			name:       "first caller",
+			remoteAddr: "192.0.2.1:1234",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "first caller again",
+			remoteAddr: "192.0.2.1:5678",
+			want:       http.StatusTooManyRequests,
+		},
+		{
+			name:       "second caller",
+			remoteAddr: "192.0.2.2:1234",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "forged address",
+			remoteAddr: "192.0.2.1:1234",
+			header:     http.Header{"Grpc-Metadata-Hugh-Gateway-Peer": {"192.0.2.3"}},
+			want:       http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/echo", strings.NewReader(`{"value":"limited"}`))
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("POST /v1/echo = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if got := w.Header().Get("Retry-After"); tt.want == http.StatusTooManyRequests && got != "10" {
+				t.Errorf("Retry-After = %q, want 10", got)
+			}
+		})
+	}
+}
+
+// TestRateLimitForgedOnLoopback calls a unix socket gateway loopback directly, claiming a different
+// HTTP caller each time the way the gateway forwards them.
+func TestRateLimitForgedOnLoopback(t *testing.T) {
+	srv := startPassthrough(t,
+		server.WithGatewayUnixSocket(filepath.Join(t.TempDir(), "gateway.sock")),
+		server.WithRateLimit("/grpcecho.EchoService/Echo", oneCall),
+	)
+
+	addr := srv.Address()
+	conn, err := grpc.Dial(addr.String(), grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, addr.Network(), addr.String())
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		peer string
+		want codes.Code
+	}{
+		{peer: "192.0.2.1", want: codes.OK},
+		{peer: "192.0.2.2", want: codes.ResourceExhausted},
+	}
+
+	for _, tt := range tests {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "hugh-gateway-peer", tt.peer)
+		_, err := grpcecho.NewEchoServiceClient(conn).Echo(ctx, &grpcecho.EchoMessage{Value: "limited"})
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("Echo() as %s = %v, want %s", tt.peer, err, tt.want)
+		}
+	}
+}
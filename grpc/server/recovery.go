@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/digital-dream-labs/hugh/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RecoveryHandler is called with every panic recovered from a handler, after it has been logged.
+type RecoveryHandler func(ctx context.Context, method string, p interface{}, stack []byte)
+
+// recovery turns panics in handlers and interceptors into codes.Internal errors.
+type recovery struct {
+	log     log.Logger
+	handler RecoveryHandler
+}
+
+func (r *recovery) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = r.recovered(ctx, info.FullMethod, p)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func (r *recovery) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = r.recovered(ss.Context(), info.FullMethod, p)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+func (r *recovery) recovered(ctx context.Context, method string, p interface{}) error {
+	stack := debug.Stack()
+
+	r.log.WithFields(log.Fields{
+		"method": method,
+		"panic":  p,
+		"stack":  string(stack),
+	}).Error("recovered from panic")
+
+	if r.handler != nil {
+		r.handler(ctx, method, p, stack)
+	}
+
+	return status.Errorf(codes.Internal, "panic in %s", method)
+}
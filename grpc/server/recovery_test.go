@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	"github.com/digital-dream-labs/hugh/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type panicEcho struct{}
+
+func (panicEcho) Echo(context.Context, *grpcecho.EchoMessage) (*grpcecho.EchoMessage, error) {
+	panic("echo exploded")
+}
+
+// syncBuffer is a bytes.Buffer the server may log to while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRecovery(t *testing.T) {
+	var logs syncBuffer
+	reg := prometheus.NewRegistry()
+
+	srv := servertest.New(t, func(s *server.Server) {
+		grpcecho.RegisterEchoServiceServer(s.Transport(), panicEcho{})
+	}, server.WithLogger(log.NewLogger(&logs)), server.WithMetricsRegistry(reg))
+
+	_, err := grpcecho.NewEchoServiceClient(srv.Conn).Echo(context.Background(), &grpcecho.EchoMessage{Value: "boom"})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("Echo() error = %v, want %s", err, codes.Internal)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, "recovered from panic") || !strings.Contains(out, "/grpcecho.EchoService/Echo") {
+		t.Errorf("log does not report the panic and the method:\n%s", out)
+	}
+
+	// Metrics run inside recovery and record the panic as the code the caller got.
+	labels := map[string]string{
+		"transport":    "grpc",
+		"grpc_type":    "unary",
+		"grpc_service": "grpcecho.EchoService",
+		"grpc_method":  "Echo",
+		"grpc_code":    codes.Internal.String(),
+	}
+	if got := value(t, reg, "grpc_server_handled_total", labels); got != 1 {
+		t.Errorf("handled with %s = %v, want 1", codes.Internal, got)
+	}
+}
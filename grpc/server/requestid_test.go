@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/requestid"
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+)
+
+func TestRequestIDGateway(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		want string
+	}{
+		{
+			name: "forwarded",
+			sent: "abc-123",
+			want: "abc-123",
+		},
+		{
+			name: "generated",
+		},
+		{
+			name: "replaced",
+			sent: "not a token",
+		},
+	}
+
+	srv := servertest.New(t, registerEcho(t), server.WithHTTPPassthroughInsecure(), server.WithRequestID())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/echo", strings.NewReader(`{"value":"id"}`))
+			if tt.sent != "" {
+				r.Header.Set(requestid.Header, tt.sent)
+			}
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("POST /v1/echo = %d %s", w.Code, w.Body)
+			}
+
+			got := w.Header().Get(requestid.Header)
+			switch {
+			case tt.want != "" && got != tt.want:
+				t.Errorf("%s = %q, want %q", requestid.Header, got, tt.want)
+			case tt.want == "" && (got == "" || got == tt.sent):
+				t.Errorf("%s = %q, want a generated ID", requestid.Header, got)
+			}
+		})
+	}
+}
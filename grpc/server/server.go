@@ -12,41 +12,58 @@ import (
 	"sync"
 	"time"
 
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/requestid"
 	"github.com/digital-dream-labs/hugh/log"
 	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
-const (
-	idletimeout       = 3
-	readheadertimeout = 1
-	maxheaderbytes    = 10 << 20
-)
+const defaultShutdownTimeout = 30 * time.Second
 
 // Server is a server struct
 type Server struct {
-	transport     *grpc.Server
-	listener      net.Listener
-	httpMux       *grpc_runtime.ServeMux
-	httpTransport *http.Server
-	httpListener  net.Listener
-	httpConfig    *tls.Config
-	state         State
-	log           log.Logger
-	notifyChan    map[State][]chan<- State
-	shutdown      func()
-	mu            sync.RWMutex
-	errs          []error
+	transport       *grpc.Server
+	listener        net.Listener
+	httpMux         *grpc_runtime.ServeMux
+	httpTransport   *http.Server
+	httpListener    net.Listener
+	httpConfig      *tls.Config
+	httpCalls       sync.RWMutex
+	certFiles       *certFiles
+	gatewayDial     func(context.Context, string) (net.Conn, error)
+	health          *healthService
+	metrics         *metrics
+	adminServer     *http.Server
+	adminListener   net.Listener
+	state           State
+	log             log.Logger
+	subs            map[*subscription]struct{}
+	shutdownTimeout time.Duration
+	systemd         *systemd
+	signals         *signals
+	stopped         chan struct{}
+	stopOnce        sync.Once
+	mu              sync.RWMutex
+	errs            []error
 }
 
 // New constructs a new Server
 func New(opts ...Option) (*Server, error) {
 	cfg := options{
-		log: log.Base(),
+		log:                   log.Base(),
+		shutdownTimeout:       defaultShutdownTimeout,
+		cors:                  defaultCORSPolicy,
+		httpIdleTimeout:       defaultHTTPIdleTimeout,
+		httpReadHeaderTimeout: defaultHTTPReadHeaderTimeout,
+		httpMaxHeaderBytes:    defaultHTTPMaxHeaderBytes,
+		shutdownSignals:       defaultShutdownSignals,
 	}
 
 	var srvOpts []grpc.ServerOption
@@ -59,6 +76,37 @@ func New(opts ...Option) (*Server, error) {
 		return nil, fmt.Errorf("error during server setup: %v", cfg.errs)
 	}
 
+	if err := cfg.cors.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.systemd {
+		lis, err := listenFDs()
+		if err != nil {
+			return nil, err
+		}
+		if len(lis) > 0 && cfg.listener == nil {
+			cfg.listener = lis[0]
+		}
+		if len(lis) > 1 {
+			cfg.adminListener = lis[1]
+		}
+	}
+
+	if cfg.certFiles != nil {
+		if cfg.keypair == nil {
+			cfg.keypair = &keypair{}
+		}
+		cfg.certFiles.kp = cfg.keypair
+		cfg.certFiles.log = cfg.log
+		cfg.certFiles.interval = cfg.certReloadInterval
+
+		if err := cfg.certFiles.load(); err != nil {
+			return nil, err
+		}
+		cfg.certificates = cfg.keypair.get()
+	}
+
 	if !cfg.insecure {
 		if (cfg.tlsCert == "" || cfg.tlsKey == "") && cfg.certificates == nil {
 			return nil, errors.New("either set insecure or define TLS certificates appropriately")
@@ -75,11 +123,56 @@ func New(opts ...Option) (*Server, error) {
 			}
 			cfg.certificates = append(cfg.certificates, crt)
 		}
+	}
 
+	if cfg.keypair == nil {
+		cfg.keypair = &keypair{}
+	}
+	cfg.keypair.set(cfg.certificates...)
+
+	if !cfg.insecure {
 		creds := credentials.NewTLS(serverTLS(&cfg))
 		srvOpts = append(srvOpts, grpc.Creds(creds))
 	}
 
+	if len(cfg.rateLimits) > 0 || cfg.defaultRateLimit != nil {
+		rl := newRateLimiter(cfg.rateLimits, cfg.defaultRateLimit, cfg.rateLimitKey)
+		cfg.ssInterceptors = append([]grpc.StreamServerInterceptor{rl.streamInterceptor}, cfg.ssInterceptors...)
+		cfg.usInterceptors = append([]grpc.UnaryServerInterceptor{rl.unaryInterceptor}, cfg.usInterceptors...)
+	}
+
+	if cfg.identity {
+		ip := identityPolicy{log: cfg.log, allow: cfg.identityAllow}
+		cfg.ssInterceptors = append([]grpc.StreamServerInterceptor{ip.streamInterceptor}, cfg.ssInterceptors...)
+		cfg.usInterceptors = append([]grpc.UnaryServerInterceptor{ip.unaryInterceptor}, cfg.usInterceptors...)
+	}
+
+	if cfg.requestID {
+		cfg.ssInterceptors = append([]grpc.StreamServerInterceptor{requestid.StreamServerInterceptor()}, cfg.ssInterceptors...)
+		cfg.usInterceptors = append([]grpc.UnaryServerInterceptor{requestid.UnaryServerInterceptor()}, cfg.usInterceptors...)
+	}
+
+	var m *metrics
+	if cfg.metrics {
+		var err error
+		if m, err = cfg.newMetrics(); err != nil {
+			return nil, err
+		}
+		// Metrics observe the outcome of every interceptor but recovery, and record panics as
+		// codes.Internal, which is what recovery turns them into.
+		cfg.ssInterceptors = append([]grpc.StreamServerInterceptor{m.streamInterceptor}, cfg.ssInterceptors...)
+		cfg.usInterceptors = append([]grpc.UnaryServerInterceptor{m.unaryInterceptor}, cfg.usInterceptors...)
+	}
+
+	// Recovery comes first so that a panic anywhere in the chain, metrics included, is caught.
+	if !cfg.noRecovery {
+		r := recovery{log: cfg.log, handler: cfg.recoveryHandler}
+		cfg.ssInterceptors = append([]grpc.StreamServerInterceptor{r.streamInterceptor}, cfg.ssInterceptors...)
+		cfg.usInterceptors = append([]grpc.UnaryServerInterceptor{r.unaryInterceptor}, cfg.usInterceptors...)
+	}
+
+	srvOpts = append(srvOpts, cfg.serverOptions()...)
+
 	if len(cfg.ssInterceptors) > 0 {
 		c := middleware.ChainStreamServer(cfg.ssInterceptors...)
 		srvOpts = append(srvOpts, grpc.StreamInterceptor(c))
@@ -91,16 +184,38 @@ func New(opts ...Option) (*Server, error) {
 	}
 
 	srv := Server{
-		state:      Init,
-		transport:  grpc.NewServer(srvOpts...),
-		log:        cfg.log,
-		notifyChan: make(map[State][]chan<- State),
+		state:           Init,
+		transport:       grpc.NewServer(srvOpts...),
+		log:             cfg.log,
+		metrics:         m,
+		certFiles:       cfg.certFiles,
+		subs:            make(map[*subscription]struct{}),
+		shutdownTimeout: cfg.shutdownTimeout,
+		stopped:         make(chan struct{}),
+	}
+
+	if cfg.systemd {
+		srv.systemd = newSystemd(cfg.log)
+	}
+
+	if !cfg.noSignals {
+		srv.signals = &signals{
+			shutdown: cfg.shutdownSignals,
+			reload:   cfg.reloadHooks,
+		}
 	}
 
-	srv.shutdown = srv.transport.GracefulStop
+	if cfg.health {
+		srv.health = newHealthService(&srv, cfg.healthInterval, cfg.healthChecks)
+		healthpb.RegisterHealthServer(srv.transport, srv.health.grpc)
+	}
 
 	if cfg.httpPassthrough || cfg.httpPassthroughInsecure {
 		srv.httpMux = grpc_runtime.NewServeMux(
+			grpc_runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+			grpc_runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+			grpc_runtime.WithErrorHandler(gatewayErrorHandler),
+			grpc_runtime.WithMetadata(gatewayMetadata),
 			grpc_runtime.WithMarshalerOption(
 				grpc_runtime.MIMEWildcard,
 				&grpc_runtime.JSONPb{
@@ -120,59 +235,97 @@ func New(opts ...Option) (*Server, error) {
 		mux := http.NewServeMux()
 		mux.Handle("/", srv.httpMux)
 
+		if srv.health != nil {
+			mux.HandleFunc("/healthz", srv.health.healthz)
+			mux.HandleFunc("/readyz", srv.health.readyz)
+		}
+
+		if srv.metrics != nil && cfg.metricsPort == 0 && cfg.adminListener == nil {
+			mux.Handle("/metrics", srv.metrics.handler())
+		}
+
 		if cfg.certificates != nil {
 			srv.httpConfig = &tls.Config{
-				Certificates: cfg.certificates,
-				NextProtos:   []string{"h2"},
-				ClientCAs:    cfg.mustGetCertPool(),
-				ClientAuth:   cfg.clientAuth,
+				GetCertificate:       cfg.keypair.getCertificate,
+				GetClientCertificate: cfg.keypair.getClientCertificate,
+				NextProtos:           []string{"h2"},
+				ClientCAs:            cfg.mustGetCertPool(),
+				ClientAuth:           cfg.clientAuth,
 				//nolint -- the only way to make this proper is to have a SAN in the cert, which may expose
 				// some of the internals.  I could go either way on this one..
 				InsecureSkipVerify: true,
 			}
 		}
 
-		srv.httpTransport = &http.Server{
-			Addr:              fmt.Sprintf(":%d", cfg.port),
-			Handler:           grpcHandlerFunc(srv.Transport(), mux),
-			TLSConfig:         srv.httpConfig,
-			IdleTimeout:       time.Second * idletimeout,
-			ReadHeaderTimeout: time.Second * readheadertimeout,
-			MaxHeaderBytes:    maxheaderbytes,
+		var h http.Handler = mux
+		if cfg.grpcWeb {
+			h = grpcWebHandler(srv.grpcHTTPHandler(), mux)
+			cfg.cors = cfg.cors.withGRPCWeb()
 		}
 
-		var err error
+		srv.httpTransport = cfg.httpServer(grpcHandlerFunc(srv.grpcHTTPHandler(), cfg.cors.handler(h)))
+		srv.httpTransport.Addr = fmt.Sprintf(":%d", cfg.port)
+		srv.httpTransport.TLSConfig = srv.httpConfig
 
-		switch {
-		case cfg.httpPassthrough:
-			srv.httpListener, err = srv.getListener(cfg.port, cfg.certificates)
-			if err != nil {
-				return nil, err
-			}
-		case cfg.httpPassthroughInsecure:
-			srv.httpListener, err = srv.getListener(cfg.port, nil)
-			if err != nil {
-				return nil, err
-			}
+		// gRPC clients reach the server over HTTP/2 on the passthrough: negotiated over TLS, or
+		// h2c without it.
+		h2 := cfg.http2Server()
+		if err := http2.ConfigureServer(srv.httpTransport, h2); err != nil {
+			return nil, err
+		}
+
+		var kp *keypair
+		if cfg.httpPassthrough && cfg.certificates != nil {
+			kp = cfg.keypair
+		}
+		if kp == nil {
+			srv.httpTransport.Handler = h2c.NewHandler(srv.httpTransport.Handler, h2)
+		}
+
+		var err error
+		srv.httpListener, err = srv.getListener(&cfg, kp)
+		if err != nil {
+			return nil, err
 		}
 
-		srv.listener, err = srv.getListener(internalServerPort, nil)
+		srv.listener, err = srv.getLoopback(cfg.loopback)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		rpcLis, _ := srv.getListener(cfg.port, nil)
-		srv.listener = rpcLis
+		var err error
+		srv.listener, err = srv.getListener(&cfg, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if cfg.reflect {
 		reflection.Register(srv.transport)
 	}
 
+	if srv.certFiles != nil {
+		if err := srv.certFiles.watch(); err != nil {
+			return nil, err
+		}
+	}
+
+	if srv.metrics != nil && (cfg.metricsPort != 0 || cfg.adminListener != nil) {
+		if err := srv.admin(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if srv.metrics == nil && cfg.adminListener != nil {
+		cfg.log.Warnf("ignoring the second systemd socket %s, since metrics are disabled", cfg.adminListener.Addr())
+		_ = cfg.adminListener.Close()
+	}
+
 	return &srv, nil
 }
 
-// Address returns the address
+// Address returns the address of the gRPC listener.  With the HTTP passthrough, gRPC clients connect to
+// HTTPAddress instead, and Address is the loopback the gateway reaches the gRPC server through.
 func (s *Server) Address() net.Addr {
 	return s.listener.Addr()
 }
@@ -185,6 +338,23 @@ func (s *Server) HTTPAddress() net.Addr {
 	return nil
 }
 
+// HTTPHandler returns the handler of the HTTP passthrough, which serves the gateway and gRPC over
+// HTTP/2, or nil without the passthrough.
+func (s *Server) HTTPHandler() http.Handler {
+	if s.httpTransport == nil {
+		return nil
+	}
+	return s.httpTransport.Handler
+}
+
+// AdminAddress returns the address of the admin port set by WithMetricsPort.
+func (s *Server) AdminAddress() net.Addr {
+	if s.adminListener != nil {
+		return s.adminListener.Addr()
+	}
+	return nil
+}
+
 // Start calls the underlying grpc.Server.Serve
 func (s *Server) Start() {
 	if s.State() != Init {
@@ -197,7 +367,13 @@ func (s *Server) Start() {
 		"http-address": s.HTTPAddress(),
 	}).Infof("server starting")
 
-	go s.handleSignals()
+	if s.signals != nil {
+		go s.handleSignals(s.notifySignals())
+	}
+
+	if s.systemd != nil {
+		go s.systemd.run(s, s.Subscribe(context.Background(), Ready, Stopping, Error, Terminating, Stopped))
+	}
 
 	s.changeState(Starting)
 
@@ -209,35 +385,84 @@ func (s *Server) Start() {
 	}()
 	if s.httpTransport != nil {
 		go func() {
-			if err := s.httpTransport.Serve(s.httpListener); err != nil {
+			if err := s.httpTransport.Serve(s.httpListener); err != nil && err != http.ErrServerClosed {
 				s.appendErr(err)
 				s.changeState(Error)
 			}
 		}()
 	}
+	if s.adminServer != nil {
+		go func() {
+			if err := s.adminServer.Serve(s.adminListener); err != nil && err != http.ErrServerClosed {
+				s.appendErr(err)
+			}
+		}()
+	}
 }
 
-// Stop shuts down the service
-func (s *Server) Stop() {
+// Stop gracefully shuts down the gRPC server and the HTTP passthrough.  If they haven't drained when ctx
+// is done or the shutdown timeout passes, the server moves to Terminating and closes every connection.
+func (s *Server) Stop(ctx context.Context) {
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+
 	s.changeState(Stopping)
-	s.shutdown()
-	s.changeState(Stopped)
-}
 
-// Notify will send requested rpc.State changes over the channel returned by this function.
-func (s *Server) Notify(states ...State) <-chan State {
-	ch := make(chan State, len(states))
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
 
-	s.mu.Lock()
-	for _, v := range states {
-		s.notifyChan[v] = append(s.notifyChan[v], ch)
-		if v == s.state {
-			ch <- s.state
+		if s.httpTransport != nil {
+			if err := s.httpTransport.Shutdown(ctx); err != nil && err != ctx.Err() {
+				s.appendErr(err)
+			}
+		}
+
+		// GracefulStop can't drain calls served over HTTP, so they finish first.
+		s.httpCalls.Lock()
+		defer s.httpCalls.Unlock()
+		s.transport.GracefulStop()
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.log.Warnf("graceful shutdown did not complete: %v", ctx.Err())
+		s.changeState(Terminating)
+		s.transport.Stop()
+		if s.httpTransport != nil {
+			if err := s.httpTransport.Close(); err != nil {
+				s.appendErr(err)
+			}
+		}
+		<-drained
+	}
+
+	if s.adminServer != nil {
+		if err := s.adminServer.Close(); err != nil {
+			s.appendErr(err)
+		}
+	}
+	if s.certFiles != nil {
+		if err := s.certFiles.close(); err != nil {
+			s.appendErr(err)
 		}
 	}
-	s.mu.Unlock()
+	if s.health != nil {
+		s.health.stop()
+	}
+	s.changeState(Stopped)
+	s.stopOnce.Do(func() { close(s.stopped) })
+}
 
-	return ch
+// Notify will send requested rpc.State changes over the channel returned by this function, until the
+// server has stopped.  The channel must be read until it is closed, or the goroutine sending on it is
+// left behind; use Subscribe to stop reading earlier.
+func (s *Server) Notify(states ...State) <-chan State {
+	return s.Subscribe(context.Background(), states...)
 }
 
 // State returns the current state.
@@ -256,6 +481,15 @@ func (s *Server) Errors() []error {
 	return e
 }
 
+// AddHealthCheck adds a dependency check to a server constructed with WithHealthService.
+func (s *Server) AddHealthCheck(name string, check HealthCheck) error {
+	if s.health == nil {
+		return errors.New("health service is not enabled")
+	}
+	s.health.addCheck(name, check)
+	return nil
+}
+
 // Transport returns the underlying grpc server.
 func (s *Server) Transport() *grpc.Server {
 	return s.transport
@@ -263,7 +497,10 @@ func (s *Server) Transport() *grpc.Server {
 
 // RegisterHTTPService accepts a slice of http gateway services to register
 func (s *Server) RegisterHTTPService(in []func(context.Context, *grpc_runtime.ServeMux, string, []grpc.DialOption) error) error {
-	dialOpts := []grpc.DialOption{}
+	dialOpts := []grpc.DialOption{
+		grpc.WithUserAgent(gatewayUserAgent),
+		grpc.WithContextDialer(s.gatewayDial),
+	}
 
 	if s.httpConfig != nil {
 		log.Info("starting http proxy with tls certificates")
@@ -281,10 +518,33 @@ func (s *Server) RegisterHTTPService(in []func(context.Context, *grpc_runtime.Se
 
 	for _, v := range in {
 		log.Debug("registering ", runtime.FuncForPC(reflect.ValueOf(v).Pointer()).Name())
-		if err := v(context.Background(), s.httpMux, fmt.Sprintf(":%d", internalServerPort), dialOpts); err != nil {
+		if err := v(context.Background(), s.httpMux, s.listener.Addr().String(), dialOpts); err != nil {
 			log.Error(err)
 			return err
 		}
 	}
 	return nil
 }
+
+// admin prepares the admin server, which serves /metrics and the health endpoints on their own port.
+func (s *Server) admin(cfg *options) error {
+	lis := cfg.adminListener
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.metricsPort)); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.handler())
+	if s.health != nil {
+		mux.HandleFunc("/healthz", s.health.healthz)
+		mux.HandleFunc("/readyz", s.health.readyz)
+	}
+
+	s.adminListener = lis
+	s.adminServer = cfg.httpServer(mux)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+// Package servertest runs a server.Server in memory for tests, with the interceptors, gateway and
+// passthrough handlers it has in production.
+package servertest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	bufconnSize = 1 << 20
+
+	// startTimeout bounds how long New waits for the server to become Ready.
+	startTimeout = 10 * time.Second
+)
+
+// Server is a server.Server listening on bufconn, with a client connected to it.
+type Server struct {
+	*server.Server
+
+	// Conn is connected to the gRPC server as a native client, over h2c with the passthrough.
+	Conn *grpc.ClientConn
+
+	// Handler serves the HTTP passthrough: the gateway, /healthz, /readyz and /metrics.  It is nil
+	// unless opts contain server.WithHTTPPassthroughInsecure.
+	Handler http.Handler
+}
+
+// New starts a server with opts, calling register to add services and gateway handlers before it
+// starts.  The server is insecure, leaves os signals alone and is stopped when the test ends.  Every
+// server listens in memory, so tests using New can run in parallel.
+func New(t testing.TB, register func(*server.Server), opts ...server.Option) *Server {
+	t.Helper()
+
+	lis := bufconn.Listen(bufconnSize)
+
+	opts = append(opts,
+		server.WithInsecureSkipVerify(),
+		server.WithoutSignalHandling(),
+		server.WithListener(lis),
+	)
+
+	srv, err := server.New(opts...)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+
+	if register != nil {
+		register(srv)
+	}
+
+	srv.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	if err := srv.WaitFor(ctx, server.Ready); err != nil {
+		srv.Stop(context.Background())
+		t.Fatalf("servertest: %v", err)
+	}
+
+	conn, err := grpc.DialContext(ctx, "bufconn",
+		// With the passthrough, lis serves HTTP and gRPC, which the client reaches over h2c.
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		srv.Stop(context.Background())
+		t.Fatalf("servertest: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		srv.Stop(context.Background())
+	})
+
+	return &Server{
+		Server:  srv,
+		Conn:    conn,
+		Handler: srv.HTTPHandler(),
+	}
+}
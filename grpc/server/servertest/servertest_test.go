@@ -0,0 +1,86 @@
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/grpc/interceptors/requestid"
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/internal/testdata/grpcecho"
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func register(t *testing.T) func(*server.Server) {
+	return func(s *server.Server) {
+		grpcecho.RegisterEchoServiceServer(s.Transport(), grpcecho.Echo{})
+		if s.HTTPHandler() == nil {
+			return
+		}
+		if err := s.RegisterHTTPService([]func(context.Context, *grpc_runtime.ServeMux, string, []grpc.DialOption) error{
+			grpcecho.RegisterEchoServiceHandlerFromEndpoint,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []server.Option
+	}{
+		{
+			name: "grpc",
+			opts: []server.Option{server.WithRequestID()},
+		},
+		{
+			name: "passthrough",
+			opts: []server.Option{server.WithRequestID(), server.WithHTTPPassthroughInsecure()},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := New(t, register(t), tt.opts...)
+
+			var header metadata.MD
+			resp, err := grpcecho.NewEchoServiceClient(srv.Conn).Echo(
+				context.Background(),
+				&grpcecho.EchoMessage{Value: "test"},
+				grpc.Header(&header),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Value != "test" {
+				t.Errorf("Echo() = %q, want %q", resp.Value, "test")
+			}
+
+			// The interceptors set up by the options ran.
+			if ids := header.Get(requestid.Header); len(ids) != 1 {
+				t.Errorf("%s header = %v, want one ID", requestid.Header, ids)
+			}
+
+			if srv.Handler == nil {
+				return
+			}
+
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/echo", strings.NewReader(`{"value":"gateway"}`)))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "gateway") {
+				t.Errorf("POST /v1/echo = %d %s", w.Code, w.Body)
+			}
+			if w.Header().Get(requestid.Header) == "" {
+				t.Errorf("POST /v1/echo has no %s header", requestid.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/digital-dream-labs/hugh/log"
+)
+
+// defaultShutdownSignals stop the server gracefully unless WithShutdownSignals says otherwise.
+var defaultShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
+
+// exit ends the process when a second shutdown signal arrives during a graceful stop.
+var exit = os.Exit
+
+// signals is how the server reacts to os signals.
+type signals struct {
+	shutdown []os.Signal
+	reload   []func()
+}
+
+// notifySignals subscribes to the shutdown signals, and to SIGHUP unless it is one of them.  An empty set
+// of shutdown signals leaves them all alone, since signal.Notify would otherwise relay every signal.
+func (s *Server) notifySignals() (shutdown, reload chan os.Signal) {
+	shutdown = make(chan os.Signal, 1)
+	if len(s.signals.shutdown) > 0 {
+		signal.Notify(shutdown, s.signals.shutdown...)
+	}
+
+	reload = make(chan os.Signal, 1)
+	if !hasSignal(s.signals.shutdown, syscall.SIGHUP) {
+		signal.Notify(reload, syscall.SIGHUP)
+	}
+
+	return shutdown, reload
+}
+
+// handleSignals stops the server on a shutdown signal and calls the reload hooks on SIGHUP, until the
+// server is stopped.  A second shutdown signal during the graceful stop exits the process.
+func (s *Server) handleSignals(shutdown, reload chan os.Signal) {
+	defer signal.Stop(shutdown)
+	defer signal.Stop(reload)
+
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-reload:
+			s.reload()
+		case x := <-shutdown:
+			s.log.WithFields(log.Fields{
+				"signal":              x,
+				"numActiveGoRoutines": runtime.NumGoroutine(),
+			}).Warn("received os signal")
+
+			go s.forceExit(shutdown)
+
+			s.log.Warn("shutting down")
+			s.Stop(context.Background())
+			s.log.Warn("shut down")
+			return
+		}
+	}
+}
+
+// forceExit exits the process if another shutdown signal arrives before the server has stopped.
+func (s *Server) forceExit(shutdown <-chan os.Signal) {
+	select {
+	case <-s.stopped:
+	case x := <-shutdown:
+		s.log.WithFields(log.Fields{"signal": x}).Error("received second os signal, exiting")
+		exit(1)
+	}
+}
+
+func (s *Server) reload() {
+	s.log.WithFields(log.Fields{"hooks": len(s.signals.reload)}).Info("received SIGHUP, reloading")
+	for _, f := range s.signals.reload {
+		f()
+	}
+}
+
+func hasSignal(list []os.Signal, sig os.Signal) bool {
+	for _, s := range list {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
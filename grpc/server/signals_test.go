@@ -0,0 +1,181 @@
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// guardSignals keeps the test signals from ending the test process once the server stops relaying them.
+func guardSignals(t *testing.T) {
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGUSR1, syscall.SIGHUP)
+	t.Cleanup(func() { signal.Stop(guard) })
+}
+
+func kill(t *testing.T, sig os.Signal) {
+	t.Helper()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startSignals(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+
+	srv, err := New(append(opts,
+		WithInsecureSkipVerify(),
+		WithListenAddress("127.0.0.1:0"),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Start()
+	t.Cleanup(func() { srv.Stop(context.Background()) })
+
+	return srv
+}
+
+func TestSignals(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		sig     os.Signal
+		stopped bool
+		reloads int
+	}{
+		{
+			name:    "shutdown",
+			opts:    []Option{WithShutdownSignals(syscall.SIGUSR1)},
+			sig:     syscall.SIGUSR1,
+			stopped: true,
+		},
+		{
+			name:    "reload",
+			sig:     syscall.SIGHUP,
+			reloads: 2,
+		},
+		{
+			name:    "SIGHUP as a shutdown signal",
+			opts:    []Option{WithShutdownSignals(syscall.SIGHUP)},
+			sig:     syscall.SIGHUP,
+			stopped: true,
+		},
+		{
+			name: "no shutdown signals",
+			opts: []Option{WithShutdownSignals()},
+			sig:  syscall.SIGUSR1,
+		},
+		{
+			name: "without signal handling",
+			opts: []Option{WithoutSignalHandling(), WithShutdownSignals(syscall.SIGUSR1)},
+			sig:  syscall.SIGUSR1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guardSignals(t)
+
+			reloaded := make(chan struct{}, 2)
+			hook := OnReload(func() { reloaded <- struct{}{} })
+			srv := startSignals(t, append(tt.opts, hook, hook)...)
+
+			kill(t, tt.sig)
+
+			for i := 0; i < tt.reloads; i++ {
+				select {
+				case <-reloaded:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%d reload hooks called, want %d", i, tt.reloads)
+				}
+			}
+
+			wait := 200 * time.Millisecond
+			if tt.stopped {
+				wait = 5 * time.Second
+			}
+			var stopped bool
+			select {
+			case <-srv.stopped:
+				stopped = true
+			case <-time.After(wait):
+			}
+			if stopped != tt.stopped {
+				t.Errorf("stopped = %v, want %v", stopped, tt.stopped)
+			}
+
+			select {
+			case <-reloaded:
+				t.Errorf("more than %d reload hooks called", tt.reloads)
+			default:
+			}
+		})
+	}
+}
+
+func TestSecondShutdownSignal(t *testing.T) {
+	guardSignals(t)
+
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	t.Cleanup(func() { exit = os.Exit })
+
+	srv := startSignals(t,
+		WithShutdownSignals(syscall.SIGUSR1),
+		WithHealthService(),
+		WithShutdownTimeout(time.Minute),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, srv.Address().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The open stream keeps the graceful stop from finishing.
+	watch, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopping := srv.Notify(Stopping)
+	kill(t, syscall.SIGUSR1)
+	select {
+	case <-stopping:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first signal did not stop the server")
+	}
+
+	kill(t, syscall.SIGUSR1)
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal did not exit")
+	}
+
+	cancel()
+}
@@ -16,6 +16,8 @@ const (
 	Stopped
 	// Error means the connection is in error
 	Error
+	// Terminating means graceful shutdown timed out and open connections are being closed
+	Terminating
 )
 
 func (s State) String() string {
@@ -32,6 +34,8 @@ func (s State) String() string {
 		return "STOPPED"
 	case Error:
 		return "ERROR"
+	case Terminating:
+		return "TERMINATING"
 	default:
 		return "INVALID"
 	}
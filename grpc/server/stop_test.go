@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/grpc/server"
+	"github.com/digital-dream-labs/hugh/grpc/server/servertest"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// TestStopHTTPStream stops the server while a grpc-web stream, which runs on a handler transport, is
+// open.
+func TestStopHTTPStream(t *testing.T) {
+	tests := []struct {
+		name string
+		// hangUp ends the stream once the server is stopping; otherwise it outlives the shutdown timeout.
+		hangUp      bool
+		terminating bool
+	}{
+		{
+			name:   "drained",
+			hangUp: true,
+		},
+		{
+			name:        "timed out",
+			terminating: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := servertest.New(t, nil,
+				server.WithHTTPPassthroughInsecure(),
+				server.WithGRPCWeb(),
+				server.WithHealthService(),
+				server.WithShutdownTimeout(200*time.Millisecond),
+			)
+
+			hs := httptest.NewServer(srv.Handler)
+			defer hs.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodPost, hs.URL+"/grpc.health.v1.Health/Watch", bytes.NewReader(frame(t, 0, &healthpb.HealthCheckRequest{})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", "application/grpc-web+proto")
+
+			resp, err := hs.Client().Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			readFrame(t, bufio.NewReader(resp.Body))
+
+			states := srv.Notify(server.Stopping, server.Terminating, server.Stopped)
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				srv.Stop(context.Background())
+			}()
+
+			if st := <-states; st != server.Stopping {
+				t.Fatalf("state = %s, want %s", st, server.Stopping)
+			}
+			if tt.hangUp {
+				cancel()
+			}
+
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Stop did not return")
+			}
+
+			var terminating bool
+			for st := range states {
+				terminating = terminating || st == server.Terminating
+			}
+			if terminating != tt.terminating {
+				t.Errorf("terminating = %v, want %v", terminating, tt.terminating)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// subscription queues the states a subscriber asked for, so that changing state never waits for the
+// subscriber to read them.
+type subscription struct {
+	states map[State]bool
+	mu     sync.Mutex
+	queue  []State
+	ended  bool
+	wake   chan struct{}
+}
+
+// push queues st if it was asked for.  Stopped ends the subscription, since the server can't leave it.
+func (sub *subscription) push(st State) {
+	sub.mu.Lock()
+	if sub.states[st] {
+		sub.queue = append(sub.queue, st)
+	}
+	if st == Stopped {
+		sub.ended = true
+	}
+	sub.mu.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscription) pop() ([]State, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	q := sub.queue
+	sub.queue = nil
+	return q, sub.ended
+}
+
+// Subscribe sends every change to one of states over the returned channel, starting with the current
+// state if it is one of them.  The channel is closed once ctx is done, which unsubscribes, or once the
+// server has stopped and every queued state has been read.  A subscriber that doesn't read never holds
+// up the server.
+func (s *Server) Subscribe(ctx context.Context, states ...State) <-chan State {
+	sub := &subscription{
+		states: make(map[State]bool, len(states)),
+		wake:   make(chan struct{}, 1),
+	}
+	for _, st := range states {
+		sub.states[st] = true
+	}
+
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	sub.push(s.state)
+	s.mu.Unlock()
+
+	ch := make(chan State)
+	go s.deliver(ctx, sub, ch)
+
+	return ch
+}
+
+func (s *Server) deliver(ctx context.Context, sub *subscription, ch chan<- State) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, sub)
+		s.mu.Unlock()
+		close(ch)
+	}()
+
+	for {
+		q, ended := sub.pop()
+		for _, st := range q {
+			select {
+			case ch <- st:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ended {
+			return
+		}
+
+		select {
+		case <-sub.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WaitFor blocks until the server enters st.  It returns an error if the server enters Error or stops
+// first, or if ctx is done.
+func (s *Server) WaitFor(ctx context.Context, st State) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for got := range s.Subscribe(ctx, st, Error, Stopped) {
+		switch got {
+		case st:
+			return nil
+		case Error:
+			return fmt.Errorf("server entered %s waiting for %s: %v", Error, st, s.Errors())
+		default:
+			return fmt.Errorf("server stopped waiting for %s", st)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("server stopped waiting for %s", st)
+}
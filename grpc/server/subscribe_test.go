@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSubscribeServer(t *testing.T) *Server {
+	t.Helper()
+
+	srv, err := New(
+		WithInsecureSkipVerify(),
+		WithListenAddress("127.0.0.1:0"),
+		WithoutSignalHandling(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop(context.Background()) })
+
+	return srv
+}
+
+// TestSubscribeNotReading stops the server while subscribers have stopped reading.
+func TestSubscribeNotReading(t *testing.T) {
+	srv := newSubscribeServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_ = srv.Subscribe(ctx, Starting, Stopping, Terminating, Stopped)
+	once := srv.Subscribe(ctx, Starting, Stopping, Terminating, Stopped)
+
+	srv.Start()
+	if st := <-once; st != Starting {
+		t.Fatalf("state = %s, want %s", st, Starting)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.Stop(context.Background())
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for subscribers")
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	srv := newSubscribeServer(t)
+	srv.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	states := srv.Subscribe(ctx, Starting, Stopping)
+	if st := <-states; st != Starting {
+		t.Fatalf("state = %s, want %s", st, Starting)
+	}
+
+	cancel()
+
+	select {
+	case st, ok := <-states:
+		if ok {
+			t.Errorf("state = %s after cancel, want the channel closed", st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	tests := []struct {
+		name string
+		// enter moves the server on from Ready.
+		enter func(*Server)
+		want  string
+	}{
+		{
+			name:  "error",
+			enter: func(s *Server) { _ = s.listener.Close() },
+			want:  "server entered " + Error.String(),
+		},
+		{
+			name:  "stopped",
+			enter: func(s *Server) { s.Stop(context.Background()) },
+			want:  "server stopped",
+		},
+		{
+			name:  "timed out",
+			enter: func(*Server) {},
+			want:  context.DeadlineExceeded.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSubscribeServer(t)
+			srv.Start()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := srv.WaitFor(ctx, Ready); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.enter(srv)
+
+			ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			// The server stops quickly, so it never enters Terminating.
+			err := srv.WaitFor(ctx, Terminating)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("WaitFor(Terminating) = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/log"
+)
+
+// listenFDsStart is the first file descriptor systemd passes, after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// statusLine keeps a STATUS message on one line.
+var statusLine = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// listenFDs returns the sockets systemd passed to this process through LISTEN_FDS, in order.  The
+// variables are unset, so that child processes don't take the sockets as theirs.
+func listenFDs() ([]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", fd)
+		if i := fd - listenFDsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		lis, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s: %v", name, err)
+		}
+		listeners = append(listeners, lis)
+	}
+
+	return listeners, nil
+}
+
+// systemd tells the service manager about state changes over NOTIFY_SOCKET, and pings its watchdog
+// when WATCHDOG_USEC is set.
+type systemd struct {
+	socket   string
+	watchdog time.Duration
+	log      log.Logger
+}
+
+// newSystemd returns nil when the process wasn't started by systemd with a notify socket.
+func newSystemd(l log.Logger) *systemd {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	sd := &systemd{
+		socket: socket,
+		log:    l,
+	}
+
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return sd
+	}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		sd.watchdog = time.Duration(usec) * time.Microsecond
+	}
+
+	return sd
+}
+
+// notify sends one message, such as "READY=1", to the notify socket.
+func (sd *systemd) notify(msg string) error {
+	addr := &net.UnixAddr{Name: sd.socket, Net: "unixgram"}
+	if strings.HasPrefix(addr.Name, "@") {
+		// An abstract socket.
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// message returns what systemd is told when the server enters st.
+func (sd *systemd) message(s *Server, st State) string {
+	switch st {
+	case Ready:
+		return "READY=1\nSTATUS=" + st.String()
+	case Stopping:
+		return "STOPPING=1\nSTATUS=" + st.String()
+	case Error:
+		// A newline would end STATUS and start another assignment.
+		return "STATUS=" + statusLine.Replace(fmt.Sprintf("%s: %v", st, s.Errors()))
+	default:
+		return "STATUS=" + st.String()
+	}
+}
+
+// run sends state changes and watchdog pings until the server has stopped.
+func (sd *systemd) run(s *Server, states <-chan State) {
+	var ping <-chan time.Time
+	if sd.watchdog > 0 {
+		// systemd recommends pinging at half the timeout.
+		t := time.NewTicker(sd.watchdog / 2)
+		defer t.Stop()
+		ping = t.C
+	}
+
+	failed := false
+	for {
+		select {
+		case st, ok := <-states:
+			if !ok {
+				return
+			}
+			failed = st == Error
+			if err := sd.notify(sd.message(s, st)); err != nil {
+				sd.log.Errorf("can't notify systemd: %v", err)
+			}
+		case <-ping:
+			// A failed server stops pinging, so that systemd restarts it.
+			if failed {
+				continue
+			}
+			if err := sd.notify("WATCHDOG=1"); err != nil {
+				sd.log.Errorf("can't ping systemd watchdog: %v", err)
+			}
+		}
+	}
+}
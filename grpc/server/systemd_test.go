@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/hugh/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSystemdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	setenv(t, "NOTIFY_SOCKET", socket)
+	setenv(t, "WATCHDOG_USEC", "20000")
+
+	srv, err := New(
+		WithInsecureSkipVerify(),
+		WithoutSignalHandling(),
+		WithListenAddress("127.0.0.1:0"),
+		WithSystemd(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Start()
+	expect(t, conn, "READY=1\nSTATUS=READY", "WATCHDOG=1")
+
+	go srv.Stop(context.Background())
+	expect(t, conn, "STOPPING=1\nSTATUS=STOPPING", "STATUS=STOPPED")
+}
+
+func TestSystemdErrorStatus(t *testing.T) {
+	s := &Server{errs: []error{errors.New("bad\nthing")}}
+
+	got := (&systemd{}).message(s, Error)
+	if want := "STATUS=ERROR: [bad thing]"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+// listenFDsDone is printed by TestListenFDsProcess once its checks have run.
+const listenFDsDone = "listenFDs: checked"
+
+// TestListenFDs passes two sockets to a process the way systemd does, as fds 3 and 4.
+func TestListenFDs(t *testing.T) {
+	for _, metrics := range []bool{true, false} {
+		t.Run(fmt.Sprintf("metrics %v", metrics), func(t *testing.T) {
+			var files []*os.File
+			var addrs []string
+			for i := 0; i < 2; i++ {
+				lis, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer lis.Close()
+
+				f, err := lis.(*net.TCPListener).File()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+
+				files = append(files, f)
+				addrs = append(addrs, lis.Addr().String())
+			}
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestListenFDsProcess$", "-test.v")
+			cmd.ExtraFiles = files
+			cmd.Env = append(os.Environ(),
+				"LISTEN_FDS=2",
+				"LISTEN_FDNAMES=grpc:admin",
+				"TEST_LISTEN_FDS_ADDRS="+strings.Join(addrs, ","),
+				"TEST_LISTEN_FDS_METRICS="+strconv.FormatBool(metrics),
+			)
+
+			out, err := cmd.CombinedOutput()
+			if err != nil || !bytes.Contains(out, []byte(listenFDsDone)) {
+				t.Errorf("%v\n%s", err, out)
+			}
+		})
+	}
+}
+
+// TestListenFDsProcess is the process TestListenFDs starts.
+func TestListenFDsProcess(t *testing.T) {
+	addrs := strings.Split(os.Getenv("TEST_LISTEN_FDS_ADDRS"), ",")
+	if len(addrs) != 2 {
+		t.Skip("started by TestListenFDs")
+	}
+	metrics := os.Getenv("TEST_LISTEN_FDS_METRICS") == "true"
+
+	// systemd sets LISTEN_PID to the process it starts.
+	setenv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	var logs bytes.Buffer
+	opts := []Option{
+		WithInsecureSkipVerify(),
+		WithoutSignalHandling(),
+		WithLogger(log.NewLogger(&logs)),
+		WithSystemd(),
+	}
+	if metrics {
+		opts = append(opts, WithMetricsRegistry(prometheus.NewRegistry()))
+	}
+
+	srv, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := srv.Address().String(); got != addrs[0] {
+		t.Errorf("Address() = %s, want fd 3 at %s", got, addrs[0])
+	}
+
+	if metrics {
+		if got := srv.AdminAddress(); got == nil || got.String() != addrs[1] {
+			t.Errorf("AdminAddress() = %v, want fd 4 at %s", got, addrs[1])
+		}
+	} else {
+		if got := srv.AdminAddress(); got != nil {
+			t.Errorf("AdminAddress() = %v without metrics, want nil", got)
+		}
+		if !strings.Contains(logs.String(), "ignoring the second systemd socket") {
+			t.Errorf("ignored socket not logged: %s", logs.String())
+		}
+	}
+
+	for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if v, ok := os.LookupEnv(k); ok {
+			t.Errorf("%s = %q, want it unset", k, v)
+		}
+	}
+
+	fmt.Println(listenFDsDone)
+}
+
+// expect reads messages from the fake notify socket until every one of want has arrived.
+func expect(t *testing.T, conn *net.UnixConn, want ...string) {
+	t.Helper()
+
+	missing := make(map[string]bool)
+	for _, w := range want {
+		missing[w] = true
+	}
+
+	buf := make([]byte, 4096)
+	for len(missing) > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("waiting for %v: %v", missing, err)
+		}
+		delete(missing, strings.TrimSpace(string(buf[:n])))
+	}
+}
+
+func setenv(t *testing.T, k, v string) {
+	t.Helper()
+
+	if err := os.Setenv(k, v); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Unsetenv(k) })
+}
@@ -4,15 +4,15 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/digital-dream-labs/hugh/config"
+	"google.golang.org/grpc/keepalive"
 )
 
-// viperize augments options based on viper config
-//
-// args are a k, v scheme. Special keys are:
-
 const (
 	// EnvironmentPrefix sets the prefix to strip from environment variables when resolving keys. Default: "DDL_RPC".
 	EnvironmentPrefix = "env-prefix"
@@ -20,64 +20,268 @@ const (
 	EnvironmentReplace = "env-replace"
 )
 
-// The remaining k,v's are treated as viper BindEnv args where k is the viper key and v is the env variable. Note that prefix is not used when specifically binding vars.
+// viperConfig holds the keys the server reads from config.
+type viperConfig struct {
+	Insecure             bool   `config:"insecure" desc:"Disable TLS"`
+	ClientAuthentication string `config:"client-authentication" enum:"NoClientCert,RequestClientCert,RequireAnyClientCert,VerifyClientCertIfGiven,RequireAndVerifyClientCert" desc:"Client certificate policy"`
+	TLSCertificate       string `config:"tls-certificate" desc:"Certificate to use for transport encryption"`
+	TLSKey               string `config:"tls-key" secret:"true" desc:"Private key that pairs with the tls certificate"`
+	TLSCA                string `config:"tls-ca" desc:"Certificate authority for client verification"`
+	Port                 int    `config:"port" min:"0" max:"65535" desc:"Listener port"`
+	ListenAddress        string `config:"listen-address" desc:"Address to listen on, such as 127.0.0.1:9000, instead of the port on every interface"`
+	UnixSocket           string `config:"unix-socket" desc:"Unix domain socket to listen on instead of a TCP port"`
+	Systemd              bool   `config:"systemd" desc:"Use sockets passed by systemd and notify it of state changes"`
+	GRPCWeb              bool   `config:"grpc-web" desc:"Serve grpc-web requests on the HTTP passthrough"`
+
+	CORSAllowedOrigins   []string      `config:"cors-allowed-origins" desc:"Origins allowed to call the HTTP passthrough, * for any"`
+	CORSAllowedHeaders   []string      `config:"cors-allowed-headers" desc:"Request headers allowed from browsers, * for any"`
+	CORSAllowedMethods   []string      `config:"cors-allowed-methods" desc:"Methods allowed from browsers"`
+	CORSExposedHeaders   []string      `config:"cors-exposed-headers" desc:"Response headers browsers may read"`
+	CORSAllowCredentials bool          `config:"cors-allow-credentials" desc:"Allow browsers to send credentials"`
+	CORSMaxAge           time.Duration `config:"cors-max-age" desc:"How long browsers may cache preflight responses"`
+
+	RateLimit         float64  `config:"rate-limit" min:"0" desc:"Calls per second allowed to each caller for each method, 0 for no limit"`
+	RateLimitBurst    int      `config:"rate-limit-burst" min:"0" desc:"Calls allowed in a burst, defaults to the rate"`
+	RateLimitBy       string   `config:"rate-limit-by" enum:"peer-ip,identity,metadata" desc:"Who calls are counted against"`
+	RateLimitMetadata string   `config:"rate-limit-metadata" desc:"Metadata key calls are counted against when rate-limit-by is metadata"`
+	RateLimitMethods  []string `config:"rate-limit-methods" desc:"Limits of methods, as method=rate or method=rate:burst"`
+
+	MaxRecvMsgSize               int           `config:"max-recv-msg-size" min:"0" desc:"Largest message in bytes the server accepts, 0 for the grpc default"`
+	MaxSendMsgSize               int           `config:"max-send-msg-size" min:"0" desc:"Largest message in bytes the server sends, 0 for unlimited"`
+	MaxConcurrentStreams         uint32        `config:"max-concurrent-streams" desc:"Concurrent streams allowed on each connection, 0 for unlimited"`
+	KeepaliveTime                time.Duration `config:"keepalive-time" desc:"Idle time after which clients are pinged"`
+	KeepaliveTimeout             time.Duration `config:"keepalive-timeout" desc:"How long to wait for a ping to be answered"`
+	KeepaliveMinTime             time.Duration `config:"keepalive-min-time" desc:"Shortest interval clients may ping at"`
+	KeepalivePermitWithoutStream bool          `config:"keepalive-permit-without-stream" desc:"Allow clients to ping without active streams"`
+	MaxConnectionIdle            time.Duration `config:"max-connection-idle" desc:"Close connections idle for this long"`
+	MaxConnectionAge             time.Duration `config:"max-connection-age" desc:"Close connections older than this"`
+	MaxConnectionAgeGrace        time.Duration `config:"max-connection-age-grace" desc:"Time calls get to finish on connections closed for their age"`
+	HTTPIdleTimeout              time.Duration `config:"http-idle-timeout" desc:"How long the HTTP passthrough keeps idle connections open"`
+	HTTPReadHeaderTimeout        time.Duration `config:"http-read-header-timeout" desc:"How long the HTTP passthrough waits for request headers"`
+	HTTPMaxHeaderBytes           int           `config:"http-max-header-bytes" min:"0" desc:"Largest request headers in bytes the HTTP passthrough accepts"`
+}
+
+func init() {
+	config.Declare("grpc/server", "DDL_RPC", viperConfig{})
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
 
+// viperize augments options based on viper config
+//
+// args are a k, v scheme. Special keys are EnvironmentPrefix and EnvironmentReplace.
+// The remaining k,v's are treated as viper BindEnv args where k is the viper key and v is the env variable. Note that prefix is not used when specifically binding vars.
 func (o *options) viperize(args ...string) error {
+	c := viperConfig{
+		Insecure:             o.insecure,
+		Port:                 o.port,
+		ListenAddress:        o.listenAddress,
+		UnixSocket:           o.unixSocket,
+		Systemd:              o.systemd,
+		GRPCWeb:              o.grpcWeb,
+		CORSAllowedOrigins:   o.cors.AllowedOrigins,
+		CORSAllowedHeaders:   o.cors.AllowedHeaders,
+		CORSAllowedMethods:   o.cors.AllowedMethods,
+		CORSExposedHeaders:   o.cors.ExposedHeaders,
+		CORSAllowCredentials: o.cors.AllowCredentials,
+		CORSMaxAge:           o.cors.MaxAge,
+
+		MaxRecvMsgSize:               o.maxRecvMsgSize,
+		MaxSendMsgSize:               o.maxSendMsgSize,
+		MaxConcurrentStreams:         o.maxConcurrentStreams,
+		KeepaliveTime:                o.keepalive.Time,
+		KeepaliveTimeout:             o.keepalive.Timeout,
+		KeepaliveMinTime:             o.keepalivePolicy.MinTime,
+		KeepalivePermitWithoutStream: o.keepalivePolicy.PermitWithoutStream,
+		MaxConnectionIdle:            o.keepalive.MaxConnectionIdle,
+		MaxConnectionAge:             o.keepalive.MaxConnectionAge,
+		MaxConnectionAgeGrace:        o.keepalive.MaxConnectionAgeGrace,
+		HTTPIdleTimeout:              o.httpIdleTimeout,
+		HTTPReadHeaderTimeout:        o.httpReadHeaderTimeout,
+		HTTPMaxHeaderBytes:           o.httpMaxHeaderBytes,
+	}
+
 	v, err := config.New("DDL_RPC", args...)
 	if err != nil {
 		return err
 	}
 
-	if v.IsSet("insecure") {
-		o.insecure = true
-	}
-
-	if v.IsSet("client-authentication") {
-		a := v.GetString("client-authentication")
-		switch a {
-		case "NoClientCert":
-			o.clientAuth = tls.NoClientCert
-		case "RequestClientCert":
-			o.clientAuth = tls.RequestClientCert
-		case "RequireAnyClientCert":
-			o.clientAuth = tls.RequireAnyClientCert
-		case "VerifyClientCertIfGiven":
-			o.clientAuth = tls.VerifyClientCertIfGiven
-		case "RequireAndVerifyClientCert":
-			o.clientAuth = tls.RequireAndVerifyClientCert
-		default:
-			return fmt.Errorf("invalid client-authentication value %q, valid values [NoClientCert, RequestClientCert, RequireAnyClientCert, VerifyClientCertIfGiven, RequireAndVerifyClientCert]", a)
-		}
+	if err := config.Decode(v, &c); err != nil {
+		return err
+	}
+
+	config.Register("grpc/server", v)
+
+	if w, ok := config.Watching(v); ok {
+		o.followKeypair(w)
 	}
 
-	if v.IsSet("tls-certificate") && v.IsSet("tls-key") {
+	o.insecure = c.Insecure
+
+	if c.ClientAuthentication != "" {
+		o.clientAuth = clientAuthTypes[c.ClientAuthentication]
+	}
+
+	if c.TLSCertificate != "" && c.TLSKey != "" {
 		crt, err := tls.X509KeyPair(
-			[]byte(v.GetString("tls-certificate")),
-			[]byte(v.GetString("tls-key")),
+			[]byte(c.TLSCertificate),
+			[]byte(c.TLSKey),
 		)
 		if err != nil {
-			log.Fatal(err)
 			return err
 		}
 		o.certificates = append(o.certificates, crt)
-		o.log.Debug("RPC::tls-certificate: ", v.GetString("tls-certificate"))
-		o.log.Debug("RPC::tls-key: ", v.GetString("tls-key"))
+		o.log.Debug("RPC::tls-certificate: ", c.TLSCertificate)
+		o.log.Debug("RPC::tls-key: ", config.Redact(c.TLSKey))
 	}
 
-	if v.IsSet("tls-ca") {
+	if c.TLSCA != "" {
 		pool := x509.NewCertPool()
-		if ok := pool.AppendCertsFromPEM([]byte(v.GetString("tls-ca"))); !ok {
+		if ok := pool.AppendCertsFromPEM([]byte(c.TLSCA)); !ok {
 			return fmt.Errorf("CA is not a valid pem file")
 		}
-		o.log.Debug("RPC::tls-ca: ", v.GetString("tls-ca"))
+		o.log.Debug("RPC::tls-ca: ", c.TLSCA)
 
 		o.certPool = pool
 	}
 
-	if v.IsSet("port") {
-		o.port = v.GetInt("port")
-		o.log.Debugf("RPC::port: %d", v.GetInt("port"))
+	o.port = c.Port
+	o.log.Debugf("RPC::port: %d", o.port)
+
+	o.listenAddress = c.ListenAddress
+	o.unixSocket = c.UnixSocket
+	if o.listenAddress != "" {
+		o.log.Debugf("RPC::listen-address: %s", o.listenAddress)
+	}
+	if o.unixSocket != "" {
+		o.log.Debugf("RPC::unix-socket: %s", o.unixSocket)
+	}
+
+	o.systemd = c.Systemd
+	o.grpcWeb = c.GRPCWeb
+
+	o.cors = CORSPolicy{
+		AllowedOrigins:   c.CORSAllowedOrigins,
+		AllowedHeaders:   c.CORSAllowedHeaders,
+		AllowedMethods:   c.CORSAllowedMethods,
+		ExposedHeaders:   c.CORSExposedHeaders,
+		AllowCredentials: c.CORSAllowCredentials,
+		MaxAge:           c.CORSMaxAge,
+	}
+	o.log.Debugf("RPC::cors-allowed-origins: %v", o.cors.AllowedOrigins)
+
+	if c.RateLimit > 0 {
+		o.defaultRateLimit = &RateLimit{Rate: c.RateLimit, Burst: burst(c.RateLimit, c.RateLimitBurst)}
+		o.log.Debugf("RPC::rate-limit: %v", *o.defaultRateLimit)
+	}
+
+	for _, m := range c.RateLimitMethods {
+		method, l, err := parseRateLimit(m)
+		if err != nil {
+			return err
+		}
+		if o.rateLimits == nil {
+			o.rateLimits = make(map[string]RateLimit)
+		}
+		o.rateLimits[method] = l
+	}
+
+	o.maxRecvMsgSize = c.MaxRecvMsgSize
+	o.maxSendMsgSize = c.MaxSendMsgSize
+	o.maxConcurrentStreams = c.MaxConcurrentStreams
+	o.keepalive = keepalive.ServerParameters{
+		MaxConnectionIdle:     c.MaxConnectionIdle,
+		MaxConnectionAge:      c.MaxConnectionAge,
+		MaxConnectionAgeGrace: c.MaxConnectionAgeGrace,
+		Time:                  c.KeepaliveTime,
+		Timeout:               c.KeepaliveTimeout,
+	}
+	o.keepalivePolicy = keepalive.EnforcementPolicy{
+		MinTime:             c.KeepaliveMinTime,
+		PermitWithoutStream: c.KeepalivePermitWithoutStream,
+	}
+	o.httpIdleTimeout = c.HTTPIdleTimeout
+	o.httpReadHeaderTimeout = c.HTTPReadHeaderTimeout
+	o.httpMaxHeaderBytes = c.HTTPMaxHeaderBytes
+
+	switch c.RateLimitBy {
+	case "peer-ip":
+		o.rateLimitKey = RateLimitByPeerIP
+	case "identity":
+		o.rateLimitKey = RateLimitByIdentity
+	case "metadata":
+		if c.RateLimitMetadata == "" {
+			return fmt.Errorf("rate-limit-metadata is required when rate-limit-by is metadata")
+		}
+		o.rateLimitKey = RateLimitByMetadata(c.RateLimitMetadata)
 	}
 
 	return nil
 }
+
+// followKeypair replaces the serving certificate whenever tls-certificate or tls-key change.
+func (o *options) followKeypair(w *config.Watcher) {
+	if o.keypair == nil {
+		o.keypair = &keypair{}
+	}
+
+	reload := func(_, _ interface{}) {
+		var c viperConfig
+		if err := config.Decode(w.Viper(), &c); err != nil {
+			o.log.Errorf("can't reload tls keypair: %v", err)
+			return
+		}
+
+		crt, err := tls.X509KeyPair(
+			[]byte(c.TLSCertificate),
+			[]byte(c.TLSKey),
+		)
+		if err != nil {
+			o.log.Errorf("can't reload tls keypair: %v", err)
+			return
+		}
+
+		o.keypair.set(crt)
+		o.log.Info("reloaded tls keypair")
+	}
+
+	w.OnChange("tls-certificate", reload)
+	w.OnChange("tls-key", reload)
+}
+
+// parseRateLimit parses method=rate or method=rate:burst.
+func parseRateLimit(s string) (string, RateLimit, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", RateLimit{}, fmt.Errorf("rate-limit-methods entry %q is not method=rate:burst", s)
+	}
+
+	spec := strings.SplitN(parts[1], ":", 2)
+	r, err := strconv.ParseFloat(spec[0], 64)
+	if err != nil {
+		return "", RateLimit{}, fmt.Errorf("rate-limit-methods entry %q: %v", s, err)
+	}
+
+	b := 0
+	if len(spec) == 2 {
+		if b, err = strconv.Atoi(spec[1]); err != nil {
+			return "", RateLimit{}, fmt.Errorf("rate-limit-methods entry %q: %v", s, err)
+		}
+	}
+
+	return parts[0], RateLimit{Rate: r, Burst: burst(r, b)}, nil
+}
+
+// burst defaults the burst of a limit to its rate.
+func burst(rate float64, b int) int {
+	if b > 0 {
+		return b
+	}
+	return int(math.Max(1, math.Ceil(rate)))
+}
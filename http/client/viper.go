@@ -2,6 +2,14 @@ package client
 
 import "github.com/digital-dream-labs/hugh/config"
 
+type viperConfig struct {
+	Target string `config:"target" desc:"Base URL requests are made against"`
+}
+
+func init() {
+	config.Declare("http/client", "DDL_HTTP", viperConfig{})
+}
+
 // viperize augments options based on viper config
 //
 // args are a k, v scheme. Special keys are:
@@ -10,14 +18,22 @@ import "github.com/digital-dream-labs/hugh/config"
 //
 // The remaining k,v's are treated as viper BindEnv args where k is the viper key and v is the env variable. Note that prefix is not used when specifically binding vars.
 func (o *options) viperize(args ...string) error {
+	c := viperConfig{
+		Target: o.target,
+	}
+
 	v, err := config.New("DDL_HTTP", args...)
 	if err != nil {
 		return err
 	}
 
-	if x := "target"; v.IsSet(x) {
-		o.target = v.GetString(x)
+	if err := config.Decode(v, &c); err != nil {
+		return err
 	}
 
+	config.Register("http/client", v)
+
+	o.target = c.Target
+
 	return nil
 }
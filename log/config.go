@@ -0,0 +1,38 @@
+package log
+
+import (
+	"fmt"
+
+	"github.com/digital-dream-labs/hugh/config"
+)
+
+// The log level and format follow these keys on every config.Watcher, starting with the values set when
+// it starts.  The keys sit under the prefix of the watched instance, so with config.New("DDL_RPC") they
+// are ddl.rpc.log.level in config files and DDL_RPC_LOG_LEVEL in the environment.  They take the same
+// values as the flags registered by AddFlags.
+const (
+	LevelKey  = "log.level"
+	FormatKey = "log.format"
+)
+
+func init() {
+	config.OnChange(LevelKey, func(_, v interface{}) {
+		if v == nil {
+			return
+		}
+		if err := baseLogger.SetLevel(fmt.Sprint(v)); err != nil {
+			baseLogger.Errorf("can't change log level: %v", err)
+			return
+		}
+		baseLogger.Infof("log level changed to %v", v)
+	})
+
+	config.OnChange(FormatKey, func(_, v interface{}) {
+		if v == nil {
+			return
+		}
+		if err := baseLogger.SetFormat(fmt.Sprint(v)); err != nil {
+			baseLogger.Errorf("can't change log format: %v", err)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/digital-dream-labs/hugh/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigStartupLevel(t *testing.T) {
+	before := origLogger.Level
+	defer func() { origLogger.Level = before }()
+	origLogger.Level = logrus.InfoLevel
+
+	if err := os.Setenv("DDL_LOGTEST_LOG_LEVEL", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("DDL_LOGTEST_LOG_LEVEL")
+
+	v, err := config.New("DDL_LOGTEST", "config-watch", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := config.Watching(v); ok {
+		defer w.Close()
+	}
+
+	if origLogger.Level != logrus.DebugLevel {
+		t.Errorf("level = %s, want the startup value debug", origLogger.Level)
+	}
+}